@@ -0,0 +1,112 @@
+// +build !windows,!plan9,!nacl
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// envBackground is set to the child process running in background
+const envBackground string = "MINSSH_BACKGROUND"
+
+// readyFd is a pipe to notify the parent process that authentication has
+// succeeded. it is passed to the child by exec.Cmd.ExtraFiles
+const readyFd uintptr = 3
+
+// backgroundChild is evaluated once and the variable is removed so that it
+// isn't inherited by commands this process runs
+var backgroundChild = func() bool {
+	v := os.Getenv(envBackground) != ""
+	os.Unsetenv(envBackground)
+	return v
+}()
+
+func isBackgroundChild() bool {
+	return backgroundChild
+}
+
+// forkBackground runs the same command as a child process and waits until
+// it authenticates and detaches from the terminal. the child process asks
+// passwords or passphrases on the terminal as usual
+func (a *app) forkBackground() (exitCode int) {
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get executable path: %s\n", err)
+		return 1
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create pipe: %s\n", err)
+		return 1
+	}
+	defer r.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), envBackground+"=1")
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{w}
+
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to start background process: %s\n", err)
+		return 1
+	}
+
+	b := make([]byte, 1)
+	if n, _ := r.Read(b); n == 1 {
+		// the child has detached successfully
+		return 0
+	}
+
+	if err = cmd.Wait(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+				return status.ExitStatus()
+			}
+		}
+		return 1
+	}
+	return 0
+}
+
+// detach makes the process a new session leader, replaces standard input
+// with /dev/null and notifies the parent process. like OpenSSH's -f,
+// standard output and error are kept so that outputs of the remote command
+// and warnings are still shown unless allOutputs is set for a master which
+// outlives the terminal
+func (a *app) detach(allOutputs bool) error {
+	if _, err := unix.Setsid(); err != nil {
+		return fmt.Errorf("failed to create new session: %s", err)
+	}
+
+	devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %s", os.DevNull, err)
+	}
+	defer devNull.Close()
+
+	fds := []int{0}
+	if allOutputs {
+		fds = append(fds, 1, 2)
+	}
+	for _, fd := range fds {
+		if err = unix.Dup2(int(devNull.Fd()), fd); err != nil {
+			return fmt.Errorf("failed to redirect standard input and outputs: %s", err)
+		}
+	}
+
+	ready := os.NewFile(readyFd, "ready")
+	if _, err = ready.Write([]byte{0}); err != nil {
+		return fmt.Errorf("failed to notify parent process: %s", err)
+	}
+	return ready.Close()
+}
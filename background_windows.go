@@ -0,0 +1,21 @@
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func isBackgroundChild() bool {
+	return false
+}
+
+func (a *app) forkBackground() (exitCode int) {
+	fmt.Fprintln(os.Stderr, "going to background is not supported on Windows")
+	return 1
+}
+
+func (a *app) detach(allOutputs bool) error {
+	return nil
+}
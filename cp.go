@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/tatsushid/minssh/pkg/minssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// copyJob is a file copy given like "src... [user@]host:dst" (upload) or
+// "[user@]host:src... dst" (download)
+type copyJob struct {
+	srcs      []string
+	dst       string
+	upload    bool
+	recursive bool
+	preserve  bool
+}
+
+// splitRemotePath splits arg like "[user@]host:path" into "[user@]host" and
+// "path". like scp, a colon after a slash or a Windows drive letter doesn't
+// make it remote
+func splitRemotePath(arg string) (userHost, p string, ok bool) {
+	i := strings.Index(arg, ":")
+	if i <= 0 {
+		return "", arg, false
+	}
+	if strings.ContainsAny(arg[:i], `/\`) {
+		return "", arg, false
+	}
+	if runtime.GOOS == "windows" && i == 1 {
+		return "", arg, false
+	}
+	return arg[:i], arg[i+1:], true
+}
+
+// parseCopyArgs parses arguments of cp mode and returns "[user@]host" to
+// connect
+func (a *app) parseCopyArgs(args []string) (userHost string, err error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("source and target must be specified")
+	}
+
+	srcs, dst := args[:len(args)-1], args[len(args)-1]
+	if dstHost, p, ok := splitRemotePath(dst); ok {
+		for _, src := range srcs {
+			if _, _, ok := splitRemotePath(src); ok {
+				return "", fmt.Errorf("copying between remote hosts is not supported")
+			}
+		}
+		a.copyJob.srcs, a.copyJob.dst, a.copyJob.upload = srcs, p, true
+		return dstHost, nil
+	}
+
+	for _, src := range srcs {
+		srcHost, p, ok := splitRemotePath(src)
+		if !ok {
+			return "", fmt.Errorf("either sources or target must be remote")
+		}
+		if userHost != "" && srcHost != userHost {
+			return "", fmt.Errorf("copying from multiple remote hosts is not supported")
+		}
+		userHost = srcHost
+		a.copyJob.srcs = append(a.copyJob.srcs, p)
+	}
+	a.copyJob.dst = dst
+	return userHost, nil
+}
+
+func (a *app) runCopy(ms *minssh.MinSSH) error {
+	client, sub, err := openSFTP(ms)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+	defer client.Close()
+
+	c := &copier{copyJob: &a.copyJob, client: client}
+	if c.cwd, err = client.Getwd(); err != nil {
+		return fmt.Errorf("failed to get remote working directory: %s", err)
+	}
+	if !a.conf.Quiet && terminal.IsTerminal(int(os.Stdout.Fd())) {
+		c.progressOut = os.Stdout
+	}
+
+	if c.upload {
+		c.uploadAll()
+	} else {
+		c.downloadAll()
+	}
+
+	if c.errCount > 0 {
+		return fmt.Errorf("failed to copy %d file(s)", c.errCount)
+	}
+	return nil
+}
+
+type copier struct {
+	*copyJob
+	client      *sftp.Client
+	cwd         string
+	progressOut io.Writer
+	errCount    int
+}
+
+func (c *copier) remotePath(p string) string {
+	if p == "" {
+		return c.cwd
+	}
+	if path.IsAbs(p) {
+		return path.Clean(p)
+	}
+	return path.Join(c.cwd, p)
+}
+
+func (c *copier) fail(err error) {
+	fmt.Fprintf(os.Stderr, "cp: %s\n", err)
+	c.errCount++
+}
+
+func (c *copier) uploadAll() {
+	dst := c.remotePath(c.dst)
+	fi, err := c.client.Stat(dst)
+	dstIsDir := err == nil && fi.IsDir()
+	if len(c.srcs) > 1 && !dstIsDir {
+		c.fail(fmt.Errorf("%s is not a directory", dst))
+		return
+	}
+
+	for _, src := range c.srcs {
+		target := dst
+		if dstIsDir {
+			target = path.Join(dst, filepath.Base(src))
+		}
+		c.uploadTree(src, target)
+	}
+}
+
+func (c *copier) uploadTree(src, dst string) {
+	fi, err := os.Stat(src)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+
+	if fi.IsDir() {
+		if !c.recursive {
+			c.fail(fmt.Errorf("%s is a directory (use -r to copy it)", src))
+			return
+		}
+		if rfi, err := c.client.Stat(dst); err != nil {
+			if err = c.client.Mkdir(dst); err != nil {
+				c.fail(fmt.Errorf("failed to create %s: %s", dst, err))
+				return
+			}
+		} else if !rfi.IsDir() {
+			c.fail(fmt.Errorf("%s is not a directory", dst))
+			return
+		}
+
+		entries, err := readLocalDir(src)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		for _, e := range entries {
+			c.uploadTree(filepath.Join(src, e), path.Join(dst, e))
+		}
+	} else if !fi.Mode().IsRegular() {
+		c.fail(fmt.Errorf("%s is not a regular file", src))
+		return
+	} else if err = upload(c.client, src, dst, c.progressOut); err != nil {
+		c.fail(fmt.Errorf("failed to upload %s: %s", src, err))
+		return
+	}
+
+	if c.preserve {
+		// local access time isn't available portably, use modification time
+		// instead
+		if err = c.client.Chtimes(dst, fi.ModTime(), fi.ModTime()); err == nil {
+			err = c.client.Chmod(dst, fi.Mode().Perm())
+		}
+		if err != nil {
+			c.fail(fmt.Errorf("failed to preserve attributes of %s: %s", dst, err))
+		}
+	}
+}
+
+func (c *copier) downloadAll() {
+	fi, err := os.Stat(c.dst)
+	dstIsDir := err == nil && fi.IsDir()
+	if len(c.srcs) > 1 && !dstIsDir {
+		c.fail(fmt.Errorf("%s is not a directory", c.dst))
+		return
+	}
+
+	for _, src := range c.srcs {
+		src = c.remotePath(src)
+		target := c.dst
+		if dstIsDir {
+			target = filepath.Join(c.dst, path.Base(src))
+		}
+		c.downloadTree(src, target)
+	}
+}
+
+func (c *copier) downloadTree(src, dst string) {
+	fi, err := c.client.Stat(src)
+	if err != nil {
+		c.fail(fmt.Errorf("%s: %s", src, err))
+		return
+	}
+
+	if fi.IsDir() {
+		if !c.recursive {
+			c.fail(fmt.Errorf("%s is a directory (use -r to copy it)", src))
+			return
+		}
+		if lfi, err := os.Stat(dst); err != nil {
+			if err = os.Mkdir(dst, 0755); err != nil {
+				c.fail(err)
+				return
+			}
+		} else if !lfi.IsDir() {
+			c.fail(fmt.Errorf("%s is not a directory", dst))
+			return
+		}
+
+		entries, err := c.client.ReadDir(src)
+		if err != nil {
+			c.fail(fmt.Errorf("%s: %s", src, err))
+			return
+		}
+		for _, e := range entries {
+			if !isPlainFileName(e.Name()) {
+				c.fail(fmt.Errorf("%s: refusing unsafe file name %q from server", src, e.Name()))
+				continue
+			}
+			c.downloadTree(path.Join(src, e.Name()), filepath.Join(dst, e.Name()))
+		}
+	} else if !fi.Mode().IsRegular() {
+		c.fail(fmt.Errorf("%s is not a regular file", src))
+		return
+	} else if err = download(c.client, src, dst, c.progressOut); err != nil {
+		c.fail(fmt.Errorf("failed to download %s: %s", src, err))
+		return
+	}
+
+	if c.preserve {
+		atime := fi.ModTime()
+		if st, ok := fi.Sys().(*sftp.FileStat); ok {
+			atime = time.Unix(int64(st.Atime), 0)
+		}
+		if err = os.Chtimes(dst, atime, fi.ModTime()); err == nil {
+			err = os.Chmod(dst, fi.Mode().Perm())
+		}
+		if err != nil {
+			c.fail(fmt.Errorf("failed to preserve attributes of %s: %s", dst, err))
+		}
+	}
+}
+
+// isPlainFileName reports whether name chosen by the server stays in the
+// directory it is joined to. like scp after CVE-2019-6111, "..", separators
+// of any platform and names like "C:x" on Windows are rejected
+func isPlainFileName(name string) bool {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return false
+	}
+	return filepath.Base(name) == name
+}
+
+func readLocalDir(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdirnames(-1)
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+func TestSplitRemotePath(t *testing.T) {
+	type splitTest struct {
+		arg      string
+		userHost string
+		p        string
+		ok       bool
+	}
+	tests := []splitTest{
+		{"host:path", "host", "path", true},
+		{"user@host:/abs/path", "user@host", "/abs/path", true},
+		{"host:", "host", "", true},
+		{"host:a:b", "host", "a:b", true},
+		{"local", "", "local", false},
+		{":path", "", ":path", false},
+		{"./a:b", "", "./a:b", false},
+		{"dir/a:b", "", "dir/a:b", false},
+		{`dir\a:b`, "", `dir\a:b`, false},
+		{"/abs/a:b", "", "/abs/a:b", false},
+	}
+	if runtime.GOOS == "windows" {
+		tests = append(tests, splitTest{`C:\Users\x`, "", `C:\Users\x`, false})
+	} else {
+		tests = append(tests, splitTest{"C:path", "C", "path", true})
+	}
+
+	for _, tt := range tests {
+		userHost, p, ok := splitRemotePath(tt.arg)
+		if userHost != tt.userHost || p != tt.p || ok != tt.ok {
+			t.Errorf("splitRemotePath(%q) = %q, %q, %v, want %q, %q, %v",
+				tt.arg, userHost, p, ok, tt.userHost, tt.p, tt.ok)
+		}
+	}
+}
+
+func TestParseCopyArgs(t *testing.T) {
+	tests := []struct {
+		args     []string
+		userHost string
+		srcs     []string
+		dst      string
+		upload   bool
+		wantErr  bool
+	}{
+		{
+			args:     []string{"a", "b", "user@host:dir"},
+			userHost: "user@host",
+			srcs:     []string{"a", "b"},
+			dst:      "dir",
+			upload:   true,
+		},
+		{
+			args:     []string{"./a:b", "host:"},
+			userHost: "host",
+			srcs:     []string{"./a:b"},
+			dst:      "",
+			upload:   true,
+		},
+		{
+			args:     []string{"host:a", "host:/b", "dir"},
+			userHost: "host",
+			srcs:     []string{"a", "/b"},
+			dst:      "dir",
+		},
+		{
+			args:     []string{"host:a", "./c:d"},
+			userHost: "host",
+			srcs:     []string{"a"},
+			dst:      "./c:d",
+		},
+		{args: []string{"host:a"}, wantErr: true},
+		{args: []string{"a", "b"}, wantErr: true},
+		{args: []string{"host:a", "b", "dir"}, wantErr: true},
+		{args: []string{"host1:a", "host2:b", "dir"}, wantErr: true},
+		{args: []string{"user@host:a", "host:b", "dir"}, wantErr: true},
+		{args: []string{"host1:a", "host2:dir"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		a := &app{}
+		userHost, err := a.parseCopyArgs(tt.args)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseCopyArgs(%q) returned no error", tt.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseCopyArgs(%q) returned error: %s", tt.args, err)
+			continue
+		}
+		job := a.copyJob
+		if userHost != tt.userHost || !reflect.DeepEqual(job.srcs, tt.srcs) || job.dst != tt.dst || job.upload != tt.upload {
+			t.Errorf("parseCopyArgs(%q) = %q, %q, %q, upload %v, want %q, %q, %q, upload %v",
+				tt.args, userHost, job.srcs, job.dst, job.upload, tt.userHost, tt.srcs, tt.dst, tt.upload)
+		}
+	}
+}
+
+func TestIsPlainFileName(t *testing.T) {
+	type nameTest struct {
+		name string
+		want bool
+	}
+	tests := []nameTest{
+		{"file.txt", true},
+		{".bashrc", true},
+		{"..file", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"a/b", false},
+		{`a\b`, false},
+		{`..\..\Users\x\.bashrc`, false},
+		{"../x", false},
+	}
+	if runtime.GOOS == "windows" {
+		tests = append(tests, nameTest{"C:x", false})
+	}
+
+	for _, tt := range tests {
+		if got := isPlainFileName(tt.name); got != tt.want {
+			t.Errorf("isPlainFileName(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// newTestSFTPClient connects a client to an in-process SFTP server serving
+// the local file system
+func newTestSFTPClient(t *testing.T) (client *sftp.Client, closeFn func()) {
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{serverR, serverW})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+
+	client, err = sftp.NewClientPipe(clientR, clientW)
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	// the client waits for the server to close the pipe
+	return client, func() {
+		server.Close()
+		client.Close()
+	}
+}
+
+type testFile struct {
+	path    string
+	content string
+	mode    os.FileMode
+}
+
+func writeTestTree(t *testing.T, root string, files []testFile, mtime time.Time) {
+	for _, f := range files {
+		p := filepath.Join(root, filepath.FromSlash(f.path))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(f.content), f.mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(p, f.mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkTestTree(t *testing.T, root string, files []testFile, mtime time.Time) {
+	for _, f := range files {
+		p := filepath.Join(root, filepath.FromSlash(f.path))
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Errorf("failed to read copied file: %s", err)
+			continue
+		}
+		if !bytes.Equal(b, []byte(f.content)) {
+			t.Errorf("%s has %q, want %q", p, b, f.content)
+		}
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if fi.Mode().Perm() != f.mode {
+			t.Errorf("%s has mode %s, want %s", p, fi.Mode().Perm(), f.mode)
+		}
+		if !fi.ModTime().Equal(mtime) {
+			t.Errorf("%s has modification time %s, want %s", p, fi.ModTime(), mtime)
+		}
+	}
+}
+
+func TestCopyRoundTrip(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes aren't preserved on Windows")
+	}
+
+	dir, err := ioutil.TempDir("", "minssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := []testFile{
+		{path: "a.txt", content: "hello", mode: 0640},
+		{path: "sub/b.txt", content: "world", mode: 0600},
+		{path: "sub/deep/c.txt", content: "", mode: 0755},
+	}
+	mtime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	src := filepath.Join(dir, "src")
+	writeTestTree(t, src, files, mtime)
+
+	client, closeFn := newTestSFTPClient(t)
+	defer closeFn()
+
+	job := &copyJob{recursive: true, preserve: true}
+	c := &copier{copyJob: job, client: client, cwd: dir}
+
+	// the in-process server shares the local file system so that the
+	// uploaded files can be checked directly
+	remote := filepath.Join(dir, "remote")
+	c.uploadTree(src, filepath.ToSlash(remote))
+	if c.errCount != 0 {
+		t.Fatalf("upload failed %d time(s)", c.errCount)
+	}
+	checkTestTree(t, remote, files, mtime)
+
+	local := filepath.Join(dir, "local")
+	c.downloadTree(filepath.ToSlash(remote), local)
+	if c.errCount != 0 {
+		t.Fatalf("download failed %d time(s)", c.errCount)
+	}
+	checkTestTree(t, local, files, mtime)
+
+	// directories aren't copied without -r
+	job.recursive = false
+	c.uploadTree(src, filepath.ToSlash(filepath.Join(dir, "norecursive")))
+	if c.errCount != 1 {
+		t.Errorf("copying a directory without -r failed %d time(s), want 1", c.errCount)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "norecursive")); !os.IsNotExist(err) {
+		t.Errorf("directory was copied without -r")
+	}
+}
+
+func TestDownloadUnsafeName(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file names with a backslash can't be made on Windows")
+	}
+
+	dir, err := ioutil.TempDir("", "minssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// on Windows the server's name below is a path to outside of the target
+	remote := filepath.Join(dir, "remote")
+	files := []testFile{
+		{path: "ok.txt", content: "ok", mode: 0644},
+		{path: `..\evil`, content: "evil", mode: 0644},
+	}
+	writeTestTree(t, remote, files, time.Now())
+
+	client, closeFn := newTestSFTPClient(t)
+	defer closeFn()
+
+	c := &copier{copyJob: &copyJob{recursive: true}, client: client, cwd: dir}
+	local := filepath.Join(dir, "local")
+	c.downloadTree(filepath.ToSlash(remote), local)
+
+	if c.errCount != 1 {
+		t.Errorf("download failed %d time(s), want 1", c.errCount)
+	}
+	if _, err := os.Stat(filepath.Join(local, "ok.txt")); err != nil {
+		t.Errorf("safe file wasn't downloaded: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(local, `..\evil`)); !os.IsNotExist(err) {
+		t.Errorf("file with unsafe name was downloaded")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/tatsushid/minssh/pkg/minssh"
+)
+
+// runKnownHosts edits known_hosts files like ssh-keygen
+func (a *app) runKnownHosts() (exitCode int) {
+	var (
+		removeHost      string
+		hash            bool
+		file            string
+		useOpenSSHFiles bool
+	)
+	a.flagSet.StringVar(&removeHost, "R", "", "remove all keys of `hostname` (\"host\" or \"[host]:port\") from known_hosts files")
+	a.flagSet.BoolVar(&hash, "H", false, "hash hostnames in known_hosts files. the original files are kept with \".old\" suffix")
+	a.flagSet.StringVar(&file, "f", "", "edit `known_hosts_file` instead of the default known_hosts files")
+	a.flagSet.BoolVar(&useOpenSSHFiles, "U", false, "also edit known_hosts files in OpenSSH's '.ssh' directory like connecting with -U")
+	a.flagSet.Parse(a.args)
+
+	// the same files as connecting are edited so that a host key found by
+	// a connection can be removed
+	if useOpenSSHFiles {
+		a.addOpenSSHKnownHostsFiles()
+	}
+	files := a.conf.KnownHostsFiles
+	if file != "" {
+		files = []string{file}
+	}
+
+	// exactly one of -R and -H must be given
+	if (removeHost != "") == hash || a.flagSet.NArg() > 0 {
+		a.flagSet.Usage()
+		return 1
+	}
+
+	exitCode = 0
+	if hash {
+		for _, f := range files {
+			n, err := minssh.HashKnownHostsFile(f)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to hash %s: %s\n", f, err)
+				exitCode = 1
+				continue
+			}
+			if n == 0 {
+				fmt.Fprintf(os.Stderr, "%s has no hostname to hash\n", f)
+				continue
+			}
+			fmt.Printf("%s updated.\n", f)
+			fmt.Printf("Original contents retained as %s.old\n", f)
+		}
+		return
+	}
+
+	for _, f := range files {
+		lines, err := minssh.RemoveKnownHost(f, removeHost)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to remove %s from %s: %s\n", removeHost, f, err)
+			exitCode = 1
+			continue
+		}
+		if len(lines) == 0 {
+			fmt.Fprintf(os.Stderr, "Host %s not found in %s\n", removeHost, f)
+			continue
+		}
+		for _, n := range lines {
+			fmt.Printf("# Host %s found: line %d\n", removeHost, n)
+		}
+		fmt.Printf("%s updated.\n", f)
+		fmt.Printf("Original contents retained as %s.old\n", f)
+	}
+	return
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 
 	"github.com/tatsushid/minssh/pkg/minssh"
@@ -42,13 +43,27 @@ func getAppName() (appName string) {
 	return
 }
 
+const (
+	modeSSH  string = ""
+	modeSFTP string = "sftp"
+	modeCP   string = "cp"
+	// modeKnownHosts edits known_hosts files without connecting
+	modeKnownHosts string = "known-hosts"
+)
+
 type app struct {
-	name    string
-	flagSet *flag.FlagSet
-	conf    *minssh.Config
-	dir     string
-	homeDir string
-	logFile *os.File
+	name       string
+	mode       string
+	args       []string
+	flagSet    *flag.FlagSet
+	conf       *minssh.Config
+	dir        string
+	homeDir    string
+	logFile    *os.File
+	background bool
+	copyJob    copyJob
+	// controlCommand is a command given by -O to send to a master process
+	controlCommand string
 }
 
 func (a *app) initApp() (err error) {
@@ -93,30 +108,196 @@ func (a *app) initApp() (err error) {
 
 func (a *app) parseArgs() (err error) {
 	var (
+		identityFiles   []string
+		localForwards   []string
+		remoteForwards  []string
+		dynamicForwards []string
+		port            int
+		proxyJump       string
+		controlMaster   bool
+		controlPath     string
+		options         []string
+		forwardAgent    bool
+		quiet           bool
+		escapeChar      string
 		logPath         string
 		useOpenSSHFiles bool
+		queryOption     string
 		showVersion     bool
 	)
 
-	a.flagSet.Var((*strSliceValue)(&a.conf.IdentityFiles), "i", "use `identity_file` for public key authentication. this can be called multiple times")
-	a.flagSet.IntVar(&a.conf.Port, "p", 22, "specify ssh server `port`")
+	a.flagSet.Var((*strSliceValue)(&identityFiles), "i", "use `identity_file` for public key authentication. this can be called multiple times")
+	// like scp, cp mode uses -p for preserving attributes and -P for port
+	portFlag := "p"
+	if a.mode == modeCP {
+		portFlag = "P"
+		a.flagSet.BoolVar(&a.copyJob.recursive, "r", false, "copy directories recursively")
+		a.flagSet.BoolVar(&a.copyJob.preserve, "p", false, "preserve modification times and modes of copied files")
+	}
+	a.flagSet.IntVar(&port, portFlag, 22, "specify ssh server `port`")
+	a.flagSet.Var((*strSliceValue)(&localForwards), "L", "forward local `[bind_address:]port:host:hostport` to the remote side. this can be called multiple times")
+	a.flagSet.Var((*strSliceValue)(&remoteForwards), "R", "forward remote `[bind_address:]port:host:hostport` to the local side. if port is 0, the server allocates it. this can be called multiple times")
+	a.flagSet.Var((*strSliceValue)(&dynamicForwards), "D", "listen local `[bind_address:]port` as SOCKS4/5 proxy to connect through the remote side. this can be called multiple times")
+	a.flagSet.Var((*strSliceValue)(&options), "o", "give `option` in the config file format like \"ProxyCommand=nc %h %p\". this can be called multiple times")
+	a.flagSet.StringVar(&proxyJump, "J", "", "connect via jump hosts given like `[user@]host[:port][,...]`")
 	a.flagSet.BoolVar(&a.conf.IsSubsystem, "s", false, "treat command as subsystem")
 	a.flagSet.StringVar(&logPath, "E", "", "specify `log_file` path. if it isn't set, it discards all log outputs")
-	a.flagSet.BoolVar(&useOpenSSHFiles, "U", false, "use keys and known_hosts files in OpenSSH's '.ssh' directory")
+	a.flagSet.BoolVar(&useOpenSSHFiles, "U", false, "use keys, known_hosts and config files in OpenSSH's '.ssh' directory")
 	a.flagSet.BoolVar(&a.conf.NoTTY, "T", false, "disable pseudo-terminal allocation")
+	a.flagSet.StringVar(&escapeChar, "e", "~", "set escape character `char` for escape sequences like \"~.\". \"none\" disables them")
+	a.flagSet.BoolVar(&quiet, "q", false, "quiet mode. suppress informational messages like the exit message")
+	a.flagSet.BoolVar(&forwardAgent, "A", false, "enable forwarding ssh-agent connection")
+	a.flagSet.BoolVar(&a.conf.NoCommand, "N", false, "do not execute a remote command. this is useful for just forwarding ports")
+	a.flagSet.BoolVar(&a.background, "f", false, "go to background after authentication. this implies -T and needs a command or -N (not supported on Windows)")
+	a.flagSet.BoolVar(&controlMaster, "M", false, "become a master sharing the connection via the control socket")
+	a.flagSet.StringVar(&controlPath, "S", "", "specify control socket path `ctl_path`. \"none\" disables connection sharing")
+	a.flagSet.StringVar(&a.controlCommand, "O", "", "send `ctl_cmd` (\"check\" or \"exit\") to the master process")
+	a.flagSet.StringVar(&queryOption, "Q", "", "list algorithms of `query_option` (\"cipher\", \"mac\", \"kex\" or \"key\") supported by this build and exit")
 	a.flagSet.BoolVar(&showVersion, "V", false, "show version and exit")
-	a.flagSet.Parse(os.Args[1:])
+	a.flagSet.Parse(a.args)
 
 	if showVersion {
 		fmt.Println(version())
 		os.Exit(0)
 	}
 
+	if queryOption != "" {
+		algos, err := minssh.SupportedAlgorithms(queryOption)
+		if err != nil {
+			return err
+		}
+		fmt.Println(strings.Join(algos, "\n"))
+		os.Exit(0)
+	}
+
+	if logPath != "" {
+		a.logFile, err = os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open logfile: %s\n", err)
+			fmt.Fprintln(os.Stderr, "will not log, just ignore it")
+		} else {
+			a.conf.Logger = log.New(a.logFile, a.name+" ", log.LstdFlags)
+		}
+	}
+
+	var userHost string
+	if a.mode == modeCP {
+		if userHost, err = a.parseCopyArgs(a.flagSet.Args()); err != nil {
+			return err
+		}
+	} else {
+		userHost = a.flagSet.Arg(0)
+	}
+	if userHost == "" {
+		return fmt.Errorf("ssh server host must be specified")
+	}
+
+	if i := strings.Index(userHost, "@"); i != -1 {
+		if err = a.conf.SetOption("User", userHost[:i]); err != nil {
+			return err
+		}
+		a.conf.Host = userHost[i+1:]
+	} else {
+		a.conf.Host = userHost
+	}
+
+	// options given by command line flags take precedence over the ones in
+	// config files
+	isFlagSet := make(map[string]bool)
+	a.flagSet.Visit(func(f *flag.Flag) {
+		isFlagSet[f.Name] = true
+	})
+	if isFlagSet[portFlag] {
+		if err = a.conf.SetOption("Port", strconv.Itoa(port)); err != nil {
+			return err
+		}
+	}
+	if isFlagSet["J"] {
+		if err = a.conf.SetOption("ProxyJump", proxyJump); err != nil {
+			return err
+		}
+	}
+	if isFlagSet["M"] && controlMaster {
+		if err = a.conf.SetOption("ControlMaster", "yes"); err != nil {
+			return err
+		}
+	}
+	if isFlagSet["S"] {
+		if err = a.conf.SetOption("ControlPath", controlPath); err != nil {
+			return err
+		}
+	}
+	if isFlagSet["q"] && quiet {
+		if err = a.conf.SetOption("LogLevel", "QUIET"); err != nil {
+			return err
+		}
+	}
+	if isFlagSet["e"] {
+		if err = a.conf.SetOption("EscapeChar", escapeChar); err != nil {
+			return err
+		}
+	}
+	if isFlagSet["A"] {
+		if err = a.conf.SetOption("ForwardAgent", strconv.FormatBool(forwardAgent)); err != nil {
+			return err
+		}
+	}
+	for _, f := range identityFiles {
+		if err = a.conf.SetOption("IdentityFile", f); err != nil {
+			return err
+		}
+	}
+	for _, f := range localForwards {
+		if err = a.conf.SetOption("LocalForward", f); err != nil {
+			return err
+		}
+	}
+	for _, f := range remoteForwards {
+		if err = a.conf.SetOption("RemoteForward", f); err != nil {
+			return err
+		}
+	}
+	for _, f := range dynamicForwards {
+		if err = a.conf.SetOption("DynamicForward", f); err != nil {
+			return err
+		}
+	}
+	for _, o := range options {
+		key, args, err := minssh.SplitConfigLine(o)
+		if err != nil {
+			return fmt.Errorf("bad option %q: %s", o, err)
+		}
+		if key == "" {
+			return fmt.Errorf("bad option %q", o)
+		}
+		if err = a.conf.SetOption(key, args...); err != nil {
+			return err
+		}
+	}
+
+	configFiles := []string{filepath.Join(a.dir, "config")}
+	if useOpenSSHFiles {
+		configFiles = append(configFiles, filepath.Join(a.homeDir, ".ssh", "config"))
+	}
+	if err = a.applyConfigFiles(configFiles); err != nil {
+		return err
+	}
+
+	// the default control socket is used only when connection sharing is
+	// asked for so that a plain invocation never uses a master silently.
+	// there isn't a unix socket on Windows
+	if runtime.GOOS != "windows" && (a.conf.ControlMaster == minssh.ControlMasterYes ||
+		a.conf.ControlMaster == minssh.ControlMasterAuto || a.controlCommand != "") {
+		if err = a.conf.SetOption("ControlPath", filepath.Join(a.dir, "control-%r@%h:%p")); err != nil {
+			return err
+		}
+	}
+
 	if len(a.conf.IdentityFiles) == 0 {
 		for _, f := range defaultIdentityFiles {
 			f = filepath.Join(a.dir, f)
 			if _, err := os.Lstat(f); err == nil {
-				a.conf.IdentityFiles = append(a.conf.IdentityFiles)
+				a.conf.IdentityFiles = append(a.conf.IdentityFiles, f)
 			}
 		}
 		if useOpenSSHFiles {
@@ -130,43 +311,73 @@ func (a *app) parseArgs() (err error) {
 	}
 
 	if useOpenSSHFiles {
-		for _, f := range defaultKnownHostsFiles {
-			f = filepath.Join(a.homeDir, ".ssh", f)
-			if _, err := os.Lstat(f); err == nil {
-				a.conf.KnownHostsFiles = append(a.conf.KnownHostsFiles, f)
-			}
-		}
+		a.addOpenSSHKnownHostsFiles()
 	}
 
-	if logPath != "" {
-		a.logFile, err = os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to open logfile: %s\n", err)
-			fmt.Fprintln(os.Stderr, "will not log, just ignore it")
-		} else {
-			a.conf.Logger = log.New(a.logFile, a.name+" ", log.LstdFlags)
+	if a.flagSet.NArg() > 1 && a.mode != modeCP {
+		if a.mode != modeSSH {
+			return fmt.Errorf("too many arguments")
 		}
+		a.conf.Command = strings.Join(a.flagSet.Args()[1:], " ")
 	}
 
-	userHost := a.flagSet.Arg(0)
-	if userHost == "" {
-		return fmt.Errorf("ssh server host must be specified")
+	if a.conf.IsSubsystem && a.conf.Command == "" {
+		return fmt.Errorf("subsystem name must be specified")
 	}
 
-	if i := strings.Index(userHost, "@"); i != -1 {
-		a.conf.User = userHost[:i]
-		a.conf.Host = userHost[i+1:]
-	} else {
-		a.conf.Host = userHost
+	if a.background {
+		if a.conf.Command == "" && !a.conf.NoCommand {
+			return fmt.Errorf("cannot go to background without a command to execute or -N")
+		}
+		a.conf.NoTTY = true
 	}
 
-	if a.flagSet.NArg() > 1 {
-		a.conf.Command = strings.Join(a.flagSet.Args()[1:], " ")
+	if a.isPersistentMaster() && isBackgroundChild() && !a.background {
+		// the child process just keeps the connection for clients
+		a.mode = modeSSH
+		a.conf.NoCommand = true
+		a.conf.NoTTY = true
 	}
 
 	return
 }
 
+// addOpenSSHKnownHostsFiles adds existing known_hosts files in OpenSSH's
+// ".ssh" directory after minssh's own ones
+func (a *app) addOpenSSHKnownHostsFiles() {
+	for _, f := range defaultKnownHostsFiles {
+		f = filepath.Join(a.homeDir, ".ssh", f)
+		if _, err := os.Lstat(f); err == nil {
+			a.conf.KnownHostsFiles = append(a.conf.KnownHostsFiles, f)
+		}
+	}
+}
+
+// isPersistentMaster reports whether a master should stay in background
+// after the first command finishes
+func (a *app) isPersistentMaster() bool {
+	return a.conf.ControlPath != "" && a.conf.ControlPersist != 0 &&
+		(a.conf.ControlMaster == minssh.ControlMasterYes || a.conf.ControlMaster == minssh.ControlMasterAuto)
+}
+
+func (a *app) applyConfigFiles(paths []string) error {
+	host := a.conf.Host
+	for _, path := range paths {
+		sshConf, err := minssh.ReadSSHConfigFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("failed to read config file: %s", err)
+		}
+		if err = sshConf.Apply(a.conf, host); err != nil {
+			return fmt.Errorf("failed to apply config file: %s", err)
+		}
+		a.conf.SSHConfigs = append(a.conf.SSHConfigs, sshConf)
+	}
+	return nil
+}
+
 func (a *app) run() (exitCode int) {
 	exitCode = 1
 
@@ -176,6 +387,10 @@ func (a *app) run() (exitCode int) {
 		return
 	}
 
+	if a.mode == modeKnownHosts {
+		return a.runKnownHosts()
+	}
+
 	err = a.parseArgs()
 	if a.logFile != nil {
 		defer a.logFile.Close()
@@ -185,21 +400,69 @@ func (a *app) run() (exitCode int) {
 		return
 	}
 
-	if a.conf.Command == "" && !a.conf.NoTTY {
+	if a.controlCommand != "" {
+		if err = minssh.ControlCommand(a.conf, a.controlCommand); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+		return 0
+	}
+
+	if a.mode == modeSSH && a.conf.Command == "" && !a.conf.NoTTY && !a.conf.NoCommand {
 		if ok, err := minssh.IsTerminal(); !ok {
 			fmt.Fprintln(os.Stderr, err)
 			return
 		}
 	}
 
+	if a.background && !isBackgroundChild() {
+		return a.forkBackground()
+	}
+
+	if a.isPersistentMaster() && !a.background && !isBackgroundChild() {
+		if !minssh.IsControlMasterRunning(a.conf) {
+			if exitCode = a.forkBackground(); exitCode != 0 {
+				return
+			}
+			exitCode = 1
+			// port forwardings are kept by the master
+			a.conf.LocalForwards = nil
+			a.conf.RemoteForwards = nil
+			a.conf.DynamicForwards = nil
+		}
+		a.conf.ControlMaster = minssh.ControlMasterNo
+	}
+
 	ms, err := minssh.Open(a.conf)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		return
+		return minssh.ExitStatusConnectionError
 	}
 	defer ms.Close()
 
-	err = ms.Run()
+	if isBackgroundChild() {
+		if a.isPersistentMaster() && !a.background && !ms.IsControlMaster() {
+			fmt.Fprintln(os.Stderr, "failed to start control master")
+			return
+		}
+		if err = a.detach(a.isPersistentMaster() && !a.background); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+	}
+
+	switch a.mode {
+	case modeSFTP:
+		err = a.runSFTP(ms)
+	case modeCP:
+		err = a.runCopy(ms)
+	default:
+		if err = ms.Run(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return minssh.ExitStatusConnectionError
+		}
+		return ms.ExitStatus()
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return
@@ -212,10 +475,20 @@ func main() {
 	appName := getAppName()
 	a := &app{
 		name:    appName,
+		mode:    modeSSH,
+		args:    os.Args[1:],
 		flagSet: flag.NewFlagSet(appName, flag.ExitOnError),
 	}
+	if len(a.args) > 0 && (a.args[0] == modeSFTP || a.args[0] == modeCP || a.args[0] == modeKnownHosts) {
+		a.mode = a.args[0]
+		a.args = a.args[1:]
+	}
 	a.flagSet.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [options] [user@]hostname\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] [user@]hostname [command]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s sftp [options] [user@]hostname\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s cp [options] [[user@]hostname:]source... [[user@]hostname:]target\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s known-hosts [-U] [-f known_hosts_file] -R hostname\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s known-hosts [-U] [-f known_hosts_file] -H\n\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "Options:\n")
 		a.flagSet.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nVersion:\n  %s", version())
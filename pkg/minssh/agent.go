@@ -0,0 +1,124 @@
+package minssh
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// agentForwardingRequest is a channel request to ask the server to forward
+// agent connections
+const agentForwardingRequest string = "auth-agent-req@openssh.com"
+
+func (ms *MinSSH) connectAgent() {
+	if ms.conf.NoAgent {
+		return
+	}
+
+	sock := ms.conf.AgentSocket
+	if sock == "" {
+		sock = os.Getenv("SSH_AUTH_SOCK")
+	} else {
+		sock = ms.conf.expandTokens(os.ExpandEnv(sock))
+	}
+	if sock == "" {
+		return
+	}
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		ms.conf.Logger.Printf("failed to connect to ssh-agent %q: %s\n", sock, err)
+		return
+	}
+	ms.conf.Logger.Printf("connected to ssh-agent %q\n", sock)
+
+	ms.agentConn = conn
+	ms.agent = agent.NewClient(conn)
+}
+
+func (ms *MinSSH) getAgentSigners() (signers []ssh.Signer) {
+	if ms.agent == nil {
+		return nil
+	}
+
+	signers, err := ms.agent.Signers()
+	if err != nil {
+		ms.conf.Logger.Printf("failed to get keys from ssh-agent: %s\n", err)
+		return nil
+	}
+	ms.conf.Logger.Printf("got %d keys from ssh-agent\n", len(signers))
+
+	return signers
+}
+
+// isKeyInAgent reports whether the public key file paired with identityFile
+// is one of agent keys. it is used to avoid asking passphrase of a private
+// key already loaded in the agent
+func isKeyInAgent(identityFile string, agentSigners []ssh.Signer) bool {
+	if len(agentSigners) == 0 {
+		return false
+	}
+
+	b, err := ioutil.ReadFile(identityFile + ".pub")
+	if err != nil {
+		return false
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(b)
+	if err != nil {
+		return false
+	}
+
+	for _, s := range agentSigners {
+		if bytes.Equal(s.PublicKey().Marshal(), pub.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ms *MinSSH) requestAgentForwarding(sess requestSender) error {
+	// agent channels from the server are handled by the master
+	if ms.isControlClient {
+		return sendAgentForwardingRequest(sess)
+	}
+	if ms.agent == nil {
+		return fmt.Errorf("no ssh-agent is available")
+	}
+
+	if err := ms.forwardAgent(); err != nil {
+		return err
+	}
+
+	return sendAgentForwardingRequest(sess)
+}
+
+// sendAgentForwardingRequest is the same as agent.RequestAgentForwarding but
+// it also works with a session channel
+func sendAgentForwardingRequest(sess requestSender) error {
+	ok, err := sess.SendRequest(agentForwardingRequest, true, nil)
+	if err == nil && !ok {
+		err = fmt.Errorf("forwarding request denied")
+	}
+	return err
+}
+
+// forwardAgent starts accepting agent channels opened by the server and
+// connects them to the local agent. it must not be called until forwarding is
+// requested because the server can use the agent through any accepted channel
+func (ms *MinSSH) forwardAgent() error {
+	ms.agentFwdMu.Lock()
+	defer ms.agentFwdMu.Unlock()
+	if ms.isAgentForwarded {
+		return nil
+	}
+	if err := agent.ForwardToAgent(ms.conn, ms.agent); err != nil {
+		return err
+	}
+	ms.isAgentForwarded = true
+	return nil
+}
@@ -0,0 +1,205 @@
+// +build !windows,!plan9,!nacl
+
+package minssh
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// writeTestKey generates a private key and writes it and its public key to
+// path and path + ".pub"
+func writeTestKey(t *testing.T, path string) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if err = ioutil.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(path+".pub", ssh.MarshalAuthorizedKey(pub), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// startTestAgent serves an in-process keyring agent holding keys on a unix
+// socket in dir
+func startTestAgent(t *testing.T, dir string, keys ...interface{}) (sock string, stop func()) {
+	keyring := agent.NewKeyring()
+	for _, k := range keys {
+		if err := keyring.Add(agent.AddedKey{PrivateKey: k}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sock = filepath.Join(dir, "agent.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				agent.ServeAgent(keyring, c)
+			}()
+		}
+	}()
+	return sock, func() { l.Close() }
+}
+
+func publicKeyOf(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	pub, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub.Marshal()
+}
+
+func closeTestAgent(ms *MinSSH) {
+	if ms.agentConn != nil {
+		ms.agentConn.Close()
+	}
+}
+
+func TestGetSignersWithAgent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	inAgent := filepath.Join(dir, "id_in_agent")
+	notInAgent := filepath.Join(dir, "id_not_in_agent")
+	agentKey := writeTestKey(t, inAgent)
+	fileKey := writeTestKey(t, notInAgent)
+
+	sock, stop := startTestAgent(t, dir, agentKey)
+	defer stop()
+
+	tests := []struct {
+		name          string
+		identityAgent string
+		useAgent      bool
+		want          [][]byte
+		fromFiles     []string
+	}{
+		{
+			name:          "agent keys first",
+			identityAgent: sock,
+			useAgent:      true,
+			want:          [][]byte{publicKeyOf(t, agentKey), publicKeyOf(t, fileKey)},
+			fromFiles:     []string{notInAgent},
+		},
+		{
+			name:          "agent disabled",
+			identityAgent: "none",
+			want:          [][]byte{publicKeyOf(t, agentKey), publicKeyOf(t, fileKey)},
+			fromFiles:     []string{inAgent, notInAgent},
+		},
+	}
+
+	for _, tt := range tests {
+		conf := NewConfig()
+		conf.IdentityFiles = []string{inAgent, notInAgent}
+		if err := conf.SetOption("IdentityAgent", tt.identityAgent); err != nil {
+			t.Fatal(err)
+		}
+		ms := &MinSSH{conf: conf, fileSigners: make(map[string]ssh.Signer)}
+		ms.connectAgent()
+		if (ms.agent != nil) != tt.useAgent {
+			t.Errorf("%s: connected to agent: %v, want %v", tt.name, ms.agent != nil, tt.useAgent)
+		}
+
+		signers, err := ms.getSigners(conf)
+		if err != nil {
+			t.Errorf("%s: getSigners returned error: %s", tt.name, err)
+			closeTestAgent(ms)
+			continue
+		}
+		if len(signers) != len(tt.want) {
+			t.Errorf("%s: got %d signers, want %d", tt.name, len(signers), len(tt.want))
+		} else {
+			for i, s := range signers {
+				if !bytes.Equal(s.PublicKey().Marshal(), tt.want[i]) {
+					t.Errorf("%s: signer %d has unexpected public key", tt.name, i)
+				}
+			}
+		}
+		if len(ms.fileSigners) != len(tt.fromFiles) {
+			t.Errorf("%s: read %d identity files, want %d", tt.name, len(ms.fileSigners), len(tt.fromFiles))
+		}
+		for _, f := range tt.fromFiles {
+			if _, ok := ms.fileSigners[f]; !ok {
+				t.Errorf("%s: identity file %q wasn't read", tt.name, f)
+			}
+		}
+		closeTestAgent(ms)
+	}
+}
+
+func TestIsKeyInAgent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	inAgent := filepath.Join(dir, "id_in_agent")
+	notInAgent := filepath.Join(dir, "id_not_in_agent")
+	agentKey := writeTestKey(t, inAgent)
+	writeTestKey(t, notInAgent)
+	noPub := filepath.Join(dir, "id_no_pub")
+	writeTestKey(t, noPub)
+	os.Remove(noPub + ".pub")
+
+	signer, err := ssh.NewSignerFromKey(agentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agentSigners := []ssh.Signer{signer}
+
+	tests := []struct {
+		identityFile string
+		signers      []ssh.Signer
+		want         bool
+	}{
+		{inAgent, agentSigners, true},
+		{notInAgent, agentSigners, false},
+		{noPub, agentSigners, false},
+		{inAgent, nil, false},
+	}
+
+	for _, tt := range tests {
+		if got := isKeyInAgent(tt.identityFile, tt.signers); got != tt.want {
+			t.Errorf("isKeyInAgent(%q, %d signers) = %v, want %v",
+				filepath.Base(tt.identityFile), len(tt.signers), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package minssh
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// algorithmSet is default and all available algorithms of a kind in
+// preference order
+type algorithmSet struct {
+	defaults  []string
+	available []string
+}
+
+func algorithmSets() map[string]algorithmSet {
+	supported := ssh.SupportedAlgorithms()
+	insecure := ssh.InsecureAlgorithms()
+
+	// ssh.Config doesn't export its default lists but sets them by
+	// SetDefaults
+	var defaults ssh.Config
+	defaults.SetDefaults()
+
+	return map[string]algorithmSet{
+		"cipher": {
+			defaults:  defaults.Ciphers,
+			available: append(supported.Ciphers, insecure.Ciphers...),
+		},
+		"mac": {
+			defaults:  defaults.MACs,
+			available: append(supported.MACs, insecure.MACs...),
+		},
+		"kex": {
+			defaults:  defaults.KeyExchanges,
+			available: append(supported.KeyExchanges, insecure.KeyExchanges...),
+		},
+		"key": {
+			defaults:  supported.HostKeys,
+			available: append(supported.HostKeys, insecure.HostKeys...),
+		},
+	}
+}
+
+// SupportedAlgorithms returns algorithms of kind ("cipher", "mac", "kex" or
+// "key") available in this build. it is used for "-Q" option
+func SupportedAlgorithms(kind string) ([]string, error) {
+	set, ok := algorithmSets()[kind]
+	if !ok {
+		return nil, fmt.Errorf("unsupported query %q. it must be one of cipher, mac, kex and key", kind)
+	}
+	return set.available, nil
+}
+
+// parseAlgorithms parses a comma separated algorithm list given like OpenSSH.
+// "+list" appends it to the defaults, "-list" removes it from the defaults
+// and "^list" puts it at the head of the defaults. otherwise it replaces the
+// defaults. each item can be a wildcard pattern
+func parseAlgorithms(kind, s string) ([]string, error) {
+	set := algorithmSets()[kind]
+
+	op := byte(0)
+	if s != "" && strings.IndexByte("+-^", s[0]) != -1 {
+		op, s = s[0], s[1:]
+	}
+	patterns := strings.Split(s, ",")
+
+	if op == '-' {
+		var list []string
+		for _, a := range set.defaults {
+			if !matchAnyPattern(patterns, a) {
+				list = append(list, a)
+			}
+		}
+		if len(list) == 0 {
+			return nil, fmt.Errorf("all %s algorithms are removed", kind)
+		}
+		return list, nil
+	}
+
+	var list []string
+	for _, p := range patterns {
+		matched := false
+		for _, a := range set.available {
+			if matchPattern(p, a) {
+				list = appendIfMissing(list, a)
+				matched = true
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("unsupported %s algorithm %q", kind, p)
+		}
+	}
+
+	switch op {
+	case '+':
+		return appendIfMissing(append([]string{}, set.defaults...), list...), nil
+	case '^':
+		return appendIfMissing(list, set.defaults...), nil
+	}
+	return list, nil
+}
+
+func appendIfMissing(list []string, items ...string) []string {
+	for _, item := range items {
+		if !containsString(list, item) {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package minssh
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAlgorithms(t *testing.T) {
+	// expected lists are made from the defaults of golang.org/x/crypto/ssh
+	// because they change between its versions
+	defaults := algorithmSets()["cipher"].defaults
+	without := func(list []string, s string) (r []string) {
+		for _, a := range list {
+			if a != s {
+				r = append(r, a)
+			}
+		}
+		return r
+	}
+	for _, a := range []string{"aes128-ctr", "aes256-ctr"} {
+		if !containsString(defaults, a) {
+			t.Fatalf("%s isn't in the default ciphers %q", a, defaults)
+		}
+	}
+
+	tests := []struct {
+		s       string
+		want    []string
+		wantErr bool
+	}{
+		{s: "aes256-ctr,aes128-ctr", want: []string{"aes256-ctr", "aes128-ctr"}},
+		{s: "aes128-ctr,aes128-ctr", want: []string{"aes128-ctr"}},
+		{s: "aes*-ctr", want: []string{"aes128-ctr", "aes192-ctr", "aes256-ctr"}},
+		{s: "+3des-cbc", want: append(append([]string{}, defaults...), "3des-cbc")},
+		{s: "+aes128-ctr", want: defaults},
+		{s: "-aes128-ctr", want: without(defaults, "aes128-ctr")},
+		{s: "-aes128-ctr,no-such-cipher", want: without(defaults, "aes128-ctr")},
+		{s: "-aes*", want: func() (r []string) {
+			for _, a := range defaults {
+				if !matchPattern("aes*", a) {
+					r = append(r, a)
+				}
+			}
+			return r
+		}()},
+		{s: "^aes256-ctr", want: append([]string{"aes256-ctr"}, without(defaults, "aes256-ctr")...)},
+		{s: "^3des-cbc", want: append([]string{"3des-cbc"}, defaults...)},
+		{s: "-*", wantErr: true},
+		{s: "no-such-cipher", wantErr: true},
+		{s: "+no-such-cipher", wantErr: true},
+		{s: "aes128-ctr,", wantErr: true},
+		{s: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseAlgorithms("cipher", tt.s)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseAlgorithms(%q) returned no error", tt.s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseAlgorithms(%q) returned error: %s", tt.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseAlgorithms(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestSupportedAlgorithms(t *testing.T) {
+	for _, kind := range []string{"cipher", "mac", "kex", "key"} {
+		list, err := SupportedAlgorithms(kind)
+		if err != nil {
+			t.Errorf("SupportedAlgorithms(%q) returned error: %s", kind, err)
+			continue
+		}
+		if len(list) == 0 {
+			t.Errorf("SupportedAlgorithms(%q) returned no algorithm", kind)
+		}
+	}
+
+	if _, err := SupportedAlgorithms("compression"); err == nil {
+		t.Error("SupportedAlgorithms(\"compression\") returned no error")
+	}
+}
@@ -1,9 +1,18 @@
 package minssh
 
 import (
+	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
 )
 
 type Config struct {
@@ -16,15 +25,418 @@ type Config struct {
 	Command         string
 	IsSubsystem     bool
 	NoTTY           bool
+	// HashKnownHosts hashes hostnames of new entries added to known_hosts
+	HashKnownHosts bool
+	// Quiet suppresses informational messages like the exit message
+	Quiet bool
+	// SendEnv is patterns of local environment variable names sent to the
+	// server. variables given by SetEnv take precedence over them
+	SendEnv []string
+	// SetEnv is environment variables sent to the server given like
+	// "NAME=VALUE"
+	SetEnv []string
+	// TerminalModes override modes of the local terminal sent with pty
+	// request
+	TerminalModes ssh.TerminalModes
+
+	// EscapeChar starts escape sequences like "~." in interactive mode. 0
+	// disables them
+	EscapeChar byte
+	// NoCommand doesn't execute any command or shell. it is useful for
+	// just forwarding ports
+	NoCommand bool
+
+	// NoAgent disables authentication with ssh-agent
+	NoAgent bool
+	// AgentSocket is a path to ssh-agent socket. if it is empty,
+	// SSH_AUTH_SOCK environment variable is used
+	AgentSocket string
+	// ForwardAgent enables forwarding ssh-agent connection to remote
+	ForwardAgent bool
+
+	LocalForwards   []*Forward
+	RemoteForwards  []*Forward
+	DynamicForwards []*Forward
+
+	// ProxyJump is a comma separated list of jump hosts given like
+	// "[user@]host[:port]"
+	ProxyJump string
+	// ProxyCommand is a command to connect to the server. ssh runs over its
+	// standard input and output. "%h", "%p" and "%r" are expanded
+	ProxyCommand string
+	// SSHConfigs are used to resolve jump hosts' settings
+	SSHConfigs []*SSHConfig
+
+	// Ciphers, MACs, KexAlgorithms and HostKeyAlgorithms are algorithms
+	// offered to the server in preference order. if they are empty, the
+	// defaults of golang.org/x/crypto/ssh are used
+	Ciphers           []string
+	MACs              []string
+	KexAlgorithms     []string
+	HostKeyAlgorithms []string
+
+	// ConnectTimeout limits time to establish TCP connection and to receive
+	// the server's host key through it, a jump host or a proxy command. if
+	// it is 0, the OS default is used and the server is waited forever
+	ConnectTimeout time.Duration
+	// ConnectionAttempts is how many times it tries to connect. if it is 0,
+	// it tries once
+	ConnectionAttempts int
+
+	// ServerAliveInterval is an interval to send keepalive requests. if it
+	// is 0, keepalive is disabled
+	ServerAliveInterval time.Duration
+	// ServerAliveCountMax is how many keepalive requests can be unanswered
+	// before disconnecting. if it is 0, 3 is used
+	ServerAliveCountMax int
+
+	// ControlMaster is one of ControlMasterNo, ControlMasterYes and
+	// ControlMasterAuto. a master shares its connection with later
+	// invocations via a unix socket at ControlPath
+	ControlMaster string
+	ControlPath   string
+	// ControlPersist is how long a master stays in background after its
+	// last client has closed. 0 means it doesn't go to background
+	ControlPersist time.Duration
+
+	// options already set by SetOption
+	setOptions map[string]bool
 }
 
 func NewConfig() *Config {
 	return &Config{
-		User:   getDefaultUser(),
-		Host:   "",
-		Port:   22,
-		Logger: log.New(ioutil.Discard, "minssh ", log.LstdFlags),
+		User:       getDefaultUser(),
+		Host:       "",
+		Port:       22,
+		Logger:     log.New(ioutil.Discard, "minssh ", log.LstdFlags),
+		EscapeChar: '~',
+	}
+}
+
+type optionSetter func(c *Config, args []string) error
+
+// optionSetters maps lower cased ssh_config keywords to functions setting
+// their values to Config
+var optionSetters = map[string]optionSetter{
+	"hostname": func(c *Config, args []string) error {
+		c.Host = strings.Replace(args[0], "%h", c.Host, -1)
+		return nil
+	},
+	"user": func(c *Config, args []string) error {
+		c.User = args[0]
+		return nil
+	},
+	"port": func(c *Config, args []string) error {
+		port, err := strconv.Atoi(args[0])
+		if err != nil || port <= 0 || port > 65535 {
+			return fmt.Errorf("invalid port %q", args[0])
+		}
+		c.Port = port
+		return nil
+	},
+	"identityfile": func(c *Config, args []string) error {
+		c.IdentityFiles = append(c.IdentityFiles, args[0])
+		return nil
+	},
+	"identityagent": func(c *Config, args []string) error {
+		switch args[0] {
+		case "none":
+			c.NoAgent = true
+		case "SSH_AUTH_SOCK":
+			c.AgentSocket = ""
+		default:
+			c.AgentSocket = args[0]
+		}
+		return nil
+	},
+	"hashknownhosts": func(c *Config, args []string) (err error) {
+		c.HashKnownHosts, err = parseYesNo(args[0])
+		return
+	},
+	"forwardagent": func(c *Config, args []string) (err error) {
+		c.ForwardAgent, err = parseYesNo(args[0])
+		return
+	},
+	"localforward": func(c *Config, args []string) error {
+		f, err := ParseForward(strings.Join(args, ":"))
+		if err != nil {
+			return err
+		}
+		c.LocalForwards = append(c.LocalForwards, f)
+		return nil
+	},
+	"remoteforward": func(c *Config, args []string) error {
+		f, err := ParseForward(strings.Join(args, ":"))
+		if err != nil {
+			return err
+		}
+		c.RemoteForwards = append(c.RemoteForwards, f)
+		return nil
+	},
+	"proxyjump": func(c *Config, args []string) error {
+		if args[0] == "none" {
+			c.ProxyJump = ""
+		} else {
+			c.ProxyJump = args[0]
+		}
+		return nil
+	},
+	"proxycommand": func(c *Config, args []string) error {
+		if args[0] == "none" {
+			c.ProxyCommand = ""
+		} else {
+			c.ProxyCommand = strings.Join(args, " ")
+		}
+		return nil
+	},
+	"controlmaster": func(c *Config, args []string) error {
+		switch strings.ToLower(args[0]) {
+		case "yes", "true":
+			c.ControlMaster = ControlMasterYes
+		case "no", "false":
+			c.ControlMaster = ControlMasterNo
+		case "auto":
+			c.ControlMaster = ControlMasterAuto
+		default:
+			return fmt.Errorf("%q is not one of yes, no and auto", args[0])
+		}
+		return nil
+	},
+	"controlpath": func(c *Config, args []string) error {
+		if args[0] == "none" {
+			c.ControlPath = ""
+		} else {
+			c.ControlPath = args[0]
+		}
+		return nil
+	},
+	"controlpersist": func(c *Config, args []string) error {
+		if b, err := parseYesNo(args[0]); err == nil {
+			c.ControlPersist = 0
+			if b {
+				c.ControlPersist = ControlPersistForever
+			}
+			return nil
+		}
+		d, err := parseDuration(args[0])
+		if err != nil {
+			return err
+		}
+		c.ControlPersist = d
+		if d == 0 {
+			c.ControlPersist = ControlPersistForever
+		}
+		return nil
+	},
+	"connecttimeout": func(c *Config, args []string) (err error) {
+		c.ConnectTimeout, err = parseDuration(args[0])
+		return
+	},
+	"connectionattempts": func(c *Config, args []string) error {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid count %q", args[0])
+		}
+		c.ConnectionAttempts = n
+		return nil
+	},
+	"sendenv": func(c *Config, args []string) error {
+		for _, p := range args {
+			// "-pattern" removes patterns given before like OpenSSH. it
+			// isn't matched as a pattern so that "-LC_*" removes only
+			// "LC_*" and not "LC_ALL"
+			if strings.HasPrefix(p, "-") {
+				var patterns []string
+				for _, q := range c.SendEnv {
+					if q != p[1:] {
+						patterns = append(patterns, q)
+					}
+				}
+				c.SendEnv = patterns
+				continue
+			}
+			c.SendEnv = append(c.SendEnv, p)
+		}
+		return nil
+	},
+	"setenv": func(c *Config, args []string) error {
+		for _, kv := range args {
+			if strings.Index(kv, "=") <= 0 {
+				return fmt.Errorf("invalid environment variable %q", kv)
+			}
+		}
+		c.SetEnv = append(c.SetEnv, args...)
+		return nil
+	},
+	"terminalmode": func(c *Config, args []string) error {
+		for _, m := range args {
+			opcode, value, err := parseTerminalMode(m)
+			if err != nil {
+				return err
+			}
+			if c.TerminalModes == nil {
+				c.TerminalModes = ssh.TerminalModes{}
+			}
+			// the first value is used like other options
+			if _, ok := c.TerminalModes[opcode]; !ok {
+				c.TerminalModes[opcode] = value
+			}
+		}
+		return nil
+	},
+	"ciphers": func(c *Config, args []string) (err error) {
+		c.Ciphers, err = parseAlgorithms("cipher", args[0])
+		return
+	},
+	"macs": func(c *Config, args []string) (err error) {
+		c.MACs, err = parseAlgorithms("mac", args[0])
+		return
+	},
+	"kexalgorithms": func(c *Config, args []string) (err error) {
+		c.KexAlgorithms, err = parseAlgorithms("kex", args[0])
+		return
+	},
+	"hostkeyalgorithms": func(c *Config, args []string) (err error) {
+		c.HostKeyAlgorithms, err = parseAlgorithms("key", args[0])
+		return
+	},
+	"escapechar": func(c *Config, args []string) (err error) {
+		c.EscapeChar, err = parseEscapeChar(args[0])
+		return
+	},
+	"loglevel": func(c *Config, args []string) error {
+		switch strings.ToUpper(args[0]) {
+		case "QUIET", "FATAL", "ERROR":
+			c.Quiet = true
+		case "INFO", "VERBOSE", "DEBUG", "DEBUG1", "DEBUG2", "DEBUG3":
+			c.Quiet = false
+		default:
+			return fmt.Errorf("unknown log level %q", args[0])
+		}
+		return nil
+	},
+	"serveraliveinterval": func(c *Config, args []string) (err error) {
+		c.ServerAliveInterval, err = parseDuration(args[0])
+		return
+	},
+	"serveralivecountmax": func(c *Config, args []string) error {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid count %q", args[0])
+		}
+		c.ServerAliveCountMax = n
+		return nil
+	},
+	"dynamicforward": func(c *Config, args []string) error {
+		f, err := ParseDynamicForward(args[0])
+		if err != nil {
+			return err
+		}
+		c.DynamicForwards = append(c.DynamicForwards, f)
+		return nil
+	},
+}
+
+// multiValueOptions are accumulated instead of using the first value
+var multiValueOptions = map[string]bool{
+	"identityfile":   true,
+	"localforward":   true,
+	"remoteforward":  true,
+	"dynamicforward": true,
+	"sendenv":        true,
+	"setenv":         true,
+	"terminalmode":   true,
+}
+
+// SetOption sets an option by its ssh_config keyword. Like OpenSSH, the first
+// obtained value is used and the later ones are ignored except for the
+// options which can be specified multiple times
+func (c *Config) SetOption(key string, args ...string) error {
+	key = strings.ToLower(key)
+	setter, ok := optionSetters[key]
+	if !ok {
+		return fmt.Errorf("unsupported option %q", key)
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("missing argument for option %q", key)
+	}
+
+	if c.setOptions == nil {
+		c.setOptions = make(map[string]bool)
+	}
+	if c.setOptions[key] && !multiValueOptions[key] {
+		return nil
+	}
+
+	if err := setter(c, args); err != nil {
+		return fmt.Errorf("bad value for option %q: %s", key, err)
+	}
+	c.setOptions[key] = true
+
+	return nil
+}
+
+func parseYesNo(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "yes", "true":
+		return true, nil
+	case "no", "false":
+		return false, nil
+	}
+	return false, fmt.Errorf("%q is neither yes nor no", s)
+}
+
+// parseDuration parses time like OpenSSH. a number without unit is seconds
+func parseDuration(s string) (time.Duration, error) {
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return d, nil
+}
+
+func (c *Config) hostport() string {
+	return net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+}
+
+// expandTokens expands "~" at the beginning and OpenSSH style "%" tokens in s
+func (c *Config) expandTokens(s string) string {
+	s = expandHomeDir(s)
+	if !strings.Contains(s, "%") {
+		return s
+	}
+
+	r := strings.NewReplacer(
+		"%%", "%",
+		"%d", getHomeDir(),
+		"%h", c.Host,
+		"%p", strconv.Itoa(c.Port),
+		"%r", c.User,
+		"%u", getDefaultUser(),
+	)
+	return r.Replace(s)
+}
+
+func expandHomeDir(s string) string {
+	if s == "~" {
+		return getHomeDir()
+	}
+	if strings.HasPrefix(s, "~/") || (runtime.GOOS == "windows" && strings.HasPrefix(s, `~\`)) {
+		return filepath.Join(getHomeDir(), s[2:])
+	}
+	return s
+}
+
+func getHomeDir() string {
+	if runtime.GOOS == "windows" {
+		if dir := os.Getenv("USERPROFILE"); dir != "" {
+			return dir
+		}
 	}
+	return os.Getenv("HOME")
 }
 
 func getDefaultUser() (username string) {
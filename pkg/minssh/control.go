@@ -0,0 +1,388 @@
+package minssh
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	ControlMasterNo   string = "no"
+	ControlMasterYes  string = "yes"
+	ControlMasterAuto string = "auto"
+)
+
+// ControlPersistForever keeps a master connection until it is stopped by
+// "-O exit"
+const ControlPersistForever time.Duration = -1
+
+// global requests to control a master process
+const (
+	controlCheckRequest string = "check@minssh"
+	controlExitRequest  string = "exit@minssh"
+)
+
+// the master and clients talk ssh protocol over the control socket. a client
+// opens channels and sends requests to the master and the master relays them
+// to the server through its connection
+type controlMaster struct {
+	path     string
+	listener net.Listener
+	config   *ssh.ServerConfig
+
+	mu      sync.Mutex
+	clients int
+	timer   *time.Timer
+	doneC   chan struct{}
+	done    bool
+}
+
+type controlCheckReply struct {
+	Pid uint32
+}
+
+func (c *Config) controlPath() string {
+	return c.expandTokens(os.ExpandEnv(c.ControlPath))
+}
+
+// dialControl connects to a master process by the control socket
+func dialControl(conf *Config) (*ssh.Client, error) {
+	path := conf.controlPath()
+	// a socket made by other users may be a fake master
+	if err := checkControlOwner(path); err != nil {
+		return nil, err
+	}
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// the control socket is protected by its file permission like OpenSSH so
+	// that the master's host key isn't verified
+	conn, chans, reqs, err := ssh.NewClientConn(c, path, &ssh.ClientConfig{
+		User:            conf.User,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return ssh.NewClient(conn, chans, reqs), nil
+}
+
+// IsControlMasterRunning reports whether a master process listens the
+// control socket given by ControlPath
+func IsControlMasterRunning(conf *Config) bool {
+	if conf.ControlPath == "" {
+		return false
+	}
+	client, err := dialControl(conf)
+	if err != nil {
+		return false
+	}
+	client.Close()
+	return true
+}
+
+// ControlCommand sends "check" or "exit" command to a master process and
+// prints its result
+func ControlCommand(conf *Config, command string) error {
+	if conf.ControlPath == "" {
+		return fmt.Errorf("no ControlPath specified for \"-O\" command")
+	}
+
+	var reqType string
+	switch command {
+	case "check":
+		reqType = controlCheckRequest
+	case "exit":
+		reqType = controlExitRequest
+	default:
+		return fmt.Errorf("unsupported control command %q", command)
+	}
+
+	client, err := dialControl(conf)
+	if err != nil {
+		return fmt.Errorf("failed to connect to control socket %q: %s", conf.controlPath(), err)
+	}
+	defer client.Close()
+
+	ok, payload, err := client.SendRequest(reqType, true, nil)
+	if err != nil {
+		return fmt.Errorf("failed to send control command: %s", err)
+	}
+	if !ok {
+		return fmt.Errorf("master refused control command %q", command)
+	}
+
+	switch command {
+	case "check":
+		var r controlCheckReply
+		if err = ssh.Unmarshal(payload, &r); err != nil {
+			return fmt.Errorf("bad reply from master: %s", err)
+		}
+		fmt.Fprintf(os.Stderr, "Master running (pid=%d)\n", r.Pid)
+	case "exit":
+		fmt.Fprintln(os.Stderr, "Exit request sent.")
+	}
+	return nil
+}
+
+func (ms *MinSSH) startControlMaster() error {
+	path := ms.conf.controlPath()
+
+	l, err := listenControl(path)
+	if err != nil {
+		// remove the socket left by a dead master and try again
+		if _, statErr := os.Stat(path); statErr != nil || IsControlMasterRunning(ms.conf) {
+			return fmt.Errorf("failed to listen control socket %q: %s", path, err)
+		}
+		ms.conf.Logger.Printf("remove stale control socket %q\n", path)
+		os.Remove(path)
+		if l, err = listenControl(path); err != nil {
+			return fmt.Errorf("failed to listen control socket %q: %s", path, err)
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		l.Close()
+		return fmt.Errorf("failed to generate control host key: %s", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		l.Close()
+		return fmt.Errorf("failed to generate control host key: %s", err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	// agent channels opened by the server are handled by the master for all
+	// clients. like OpenSSH, they are accepted only after forwarding is
+	// requested by the master itself or by one of clients
+	if ms.conf.ForwardAgent && ms.agent != nil {
+		if err = ms.forwardAgent(); err != nil {
+			ms.conf.Logger.Printf("failed to set up agent forwarding: %s\n", err)
+		}
+	}
+
+	ms.control = &controlMaster{
+		path:     path,
+		listener: l,
+		config:   config,
+		doneC:    make(chan struct{}),
+	}
+	ms.conf.Logger.Printf("control master listens on %q\n", path)
+	ms.control.resetIdleTimer(ms.conf.ControlPersist)
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				ms.conf.Logger.Printf("control master on %q stopped: %s\n", path, err)
+				return
+			}
+			go ms.serveControlConn(c)
+		}
+	}()
+
+	return nil
+}
+
+// resetIdleTimer starts a timer to stop the master after persist when there
+// isn't any client
+func (cm *controlMaster) resetIdleTimer(persist time.Duration) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if cm.timer != nil {
+		cm.timer.Stop()
+		cm.timer = nil
+	}
+	if persist <= 0 || cm.clients > 0 || cm.done {
+		return
+	}
+	cm.timer = time.AfterFunc(persist, cm.stop)
+}
+
+func (cm *controlMaster) addClient(n int) {
+	cm.mu.Lock()
+	cm.clients += n
+	cm.mu.Unlock()
+}
+
+func (cm *controlMaster) stop() {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if !cm.done {
+		cm.done = true
+		close(cm.doneC)
+	}
+}
+
+// IsControlMaster reports whether it shares its connection by ControlPath
+func (ms *MinSSH) IsControlMaster() bool {
+	return ms.control != nil
+}
+
+func (ms *MinSSH) closeControl() {
+	if ms.control == nil {
+		return
+	}
+	ms.control.listener.Close()
+	os.Remove(ms.control.path)
+	ms.control.stop()
+}
+
+// controlDone returns a channel closed when the master is requested to exit
+// or idle time exceeds ControlPersist. it returns nil if it isn't a master
+func (ms *MinSSH) controlDone() <-chan struct{} {
+	if ms.control == nil {
+		return nil
+	}
+	return ms.control.doneC
+}
+
+func (ms *MinSSH) serveControlConn(c net.Conn) {
+	defer c.Close()
+
+	conn, chans, reqs, err := ssh.NewServerConn(c, ms.control.config)
+	if err != nil {
+		ms.conf.Logger.Printf("control master: handshake failed: %s\n", err)
+		return
+	}
+	defer conn.Close()
+	ms.conf.Logger.Printf("control master: new client\n")
+
+	ms.control.addClient(1)
+	defer func() {
+		ms.control.addClient(-1)
+		ms.control.resetIdleTimer(ms.conf.ControlPersist)
+		ms.conf.Logger.Printf("control master: client closed\n")
+	}()
+	ms.control.resetIdleTimer(ms.conf.ControlPersist)
+
+	go ms.handleControlRequests(reqs)
+
+	for nc := range chans {
+		go ms.relayControlChannel(nc)
+	}
+}
+
+func (ms *MinSSH) handleControlRequests(reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		switch req.Type {
+		case controlCheckRequest:
+			req.Reply(true, ssh.Marshal(controlCheckReply{Pid: uint32(os.Getpid())}))
+		case controlExitRequest:
+			ms.conf.Logger.Printf("control master: got exit request\n")
+			req.Reply(true, nil)
+			ms.control.stop()
+			ms.conn.Close()
+		case "tcpip-forward", "cancel-tcpip-forward":
+			// connections to remote forwarded ports reach the master and it
+			// can't tell which client they belong to
+			req.Reply(false, nil)
+		default:
+			ok, payload, err := ms.conn.SendRequest(req.Type, req.WantReply, req.Payload)
+			if err != nil {
+				ms.conf.Logger.Printf("control master: failed to relay request %q: %s\n", req.Type, err)
+			}
+			req.Reply(ok, payload)
+		}
+	}
+}
+
+// relayControlChannel opens the same channel to the server as the client
+// requests and relays data and requests between them
+func (ms *MinSSH) relayControlChannel(nc ssh.NewChannel) {
+	rch, rreqs, err := ms.conn.OpenChannel(nc.ChannelType(), nc.ExtraData())
+	if err != nil {
+		if openErr, ok := err.(*ssh.OpenChannelError); ok {
+			nc.Reject(openErr.Reason, openErr.Message)
+		} else {
+			nc.Reject(ssh.ConnectionFailed, err.Error())
+		}
+		return
+	}
+	defer rch.Close()
+
+	lch, lreqs, err := nc.Accept()
+	if err != nil {
+		ms.conf.Logger.Printf("control master: failed to accept channel: %s\n", err)
+		return
+	}
+	defer lch.Close()
+
+	go func() {
+		io.Copy(rch, lch)
+		rch.CloseWrite()
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(lch, rch)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(lch.Stderr(), rch.Stderr())
+	}()
+
+	// the server closing the channel finishes requests from it and the
+	// client closing it finishes requests from the client
+	go func() {
+		ms.relayClientChannelRequests(lreqs, rch)
+		rch.Close()
+	}()
+	for req := range rreqs {
+		relayChannelRequest(req, lch)
+	}
+
+	wg.Wait()
+	lch.CloseWrite()
+}
+
+// relayClientChannelRequests relays requests from a client to the server. an
+// agent forwarding request starts handling agent channels from the server
+// because the master has to serve them with its own agent connection
+func (ms *MinSSH) relayClientChannelRequests(reqs <-chan *ssh.Request, ch ssh.Channel) {
+	for req := range reqs {
+		if req.Type == agentForwardingRequest {
+			if ms.agent == nil {
+				ms.conf.Logger.Printf("control master: no ssh-agent to forward\n")
+				if req.WantReply {
+					req.Reply(false, nil)
+				}
+				continue
+			}
+			if err := ms.forwardAgent(); err != nil {
+				ms.conf.Logger.Printf("control master: failed to set up agent forwarding: %s\n", err)
+				if req.WantReply {
+					req.Reply(false, nil)
+				}
+				continue
+			}
+		}
+		relayChannelRequest(req, ch)
+	}
+}
+
+func relayChannelRequest(req *ssh.Request, ch ssh.Channel) {
+	ok, err := ch.SendRequest(req.Type, req.WantReply, req.Payload)
+	if err != nil {
+		ok = false
+	}
+	if req.WantReply {
+		req.Reply(ok, nil)
+	}
+}
@@ -0,0 +1,151 @@
+// +build !windows,!plan9,!nacl
+
+package minssh
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestControlMasterAgentForwarding(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conns := make(chan *ssh.ServerConn, 1)
+	l, _, _ := startTestServer(t, testServerOptions{conns: conns, sessions: true})
+	defer l.Close()
+
+	client, err := ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{
+		User:            "alice",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	serverConn := <-conns
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := agent.NewKeyring()
+	if err = keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := NewConfig()
+	conf.User = "alice"
+	conf.ControlPath = filepath.Join(dir, "control")
+	ms := &MinSSH{conf: conf, conn: client, agent: keyring.(agent.ExtendedAgent)}
+	if err = ms.startControlMaster(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		ms.closeControl()
+		if _, err := os.Lstat(conf.ControlPath); !os.IsNotExist(err) {
+			t.Error("control socket was left after the master stopped")
+		}
+	}()
+
+	fi, err := os.Stat(conf.ControlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("control socket has mode %s, want %s", perm, os.FileMode(0600))
+	}
+
+	// ForwardAgent isn't set to the master and no client has requested it
+	if ch, _, err := serverConn.OpenChannel("auth-agent@openssh.com", nil); err == nil {
+		ch.Close()
+		t.Fatal("agent channel was accepted without agent forwarding request")
+	}
+
+	controlClient, err := dialControl(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer controlClient.Close()
+	sess, err := controlClient.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Close()
+	if err = agent.RequestAgentForwarding(sess); err != nil {
+		t.Fatalf("agent forwarding request was refused: %s", err)
+	}
+
+	ch, reqs, err := serverConn.OpenChannel("auth-agent@openssh.com", nil)
+	if err != nil {
+		t.Fatalf("agent channel was rejected after agent forwarding request: %s", err)
+	}
+	defer ch.Close()
+	go ssh.DiscardRequests(reqs)
+
+	keys, err := agent.NewClient(ch).List()
+	if err != nil {
+		t.Fatalf("failed to list keys through forwarded agent: %s", err)
+	}
+	if len(keys) != 1 {
+		t.Errorf("got %d keys through forwarded agent, want 1", len(keys))
+	}
+}
+
+func TestListenControl(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := NewConfig()
+	conf.ControlPath = filepath.Join(dir, "control")
+
+	l, err := listenControl(conf.ControlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Lstat(conf.ControlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 || fi.Mode().Perm() != 0600 {
+		t.Errorf("control socket has mode %s, want socket with %s", fi.Mode(), os.FileMode(0600))
+	}
+	// only the control socket is left in the directory
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("listenControl left %d files, want 1", len(files))
+	}
+
+	if l2, err := listenControl(conf.ControlPath); err == nil {
+		l2.Close()
+		t.Error("listenControl replaced existing control socket")
+	}
+
+	l.Close()
+	if _, err = os.Lstat(conf.ControlPath); err != nil {
+		t.Errorf("closing the listener removed the control socket: %s", err)
+	}
+	os.Remove(conf.ControlPath)
+
+	// a file which isn't a socket isn't connected
+	if err = ioutil.WriteFile(conf.ControlPath, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if client, err := dialControl(conf); err == nil {
+		client.Close()
+		t.Error("dialControl connected to a regular file")
+	}
+}
@@ -0,0 +1,232 @@
+package minssh
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// waits between connection attempts. it is doubled on each failure
+const (
+	initialRetryWait time.Duration = 1 * time.Second
+	maxRetryWait     time.Duration = 30 * time.Second
+)
+
+func (ms *MinSSH) dial() (err error) {
+	config := ms.clientConfig(ms.conf)
+
+	if ms.conf.ProxyCommand != "" {
+		c, err := ms.startProxyCommand()
+		if err != nil {
+			return err
+		}
+		ms.conf.Logger.Printf("connecting to %s via proxy command\n", ms.Hostport())
+		ms.conn, err = newClient(c, ms.Hostport(), config, ms.conf.ConnectTimeout)
+		return err
+	}
+
+	if ms.conf.ProxyJump == "" {
+		ms.conf.Logger.Printf("connecting to %s\n", ms.Hostport())
+		ms.conn, err = ms.dialSSH(ms.conf, config)
+		return err
+	}
+
+	var client *ssh.Client
+	for _, spec := range strings.Split(ms.conf.ProxyJump, ",") {
+		hop, err := ms.jumpHostConfig(spec)
+		if err != nil {
+			return err
+		}
+
+		ms.conf.Logger.Printf("connecting to jump host %s@%s\n", hop.User, hop.hostport())
+		if client == nil {
+			client, err = ms.dialSSH(hop, ms.clientConfig(hop))
+		} else {
+			client, err = dialThrough(client, hop.hostport(), ms.clientConfig(hop), hop.ConnectTimeout)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to connect to jump host %s: %s", hop.hostport(), err)
+		}
+		ms.jumpConns = append(ms.jumpConns, client)
+	}
+
+	ms.conf.Logger.Printf("connecting to %s via jump host\n", ms.Hostport())
+	ms.conn, err = dialThrough(client, ms.Hostport(), config, ms.conf.ConnectTimeout)
+	return err
+}
+
+// dialSSH connects to the server given by conf and runs ssh handshake
+func (ms *MinSSH) dialSSH(conf *Config, config *ssh.ClientConfig) (*ssh.Client, error) {
+	c, err := ms.dialTCP(conf)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(c, conf.hostport(), config, conf.ConnectTimeout)
+}
+
+// dialTCP tries to connect up to ConnectionAttempts times. like OpenSSH, only
+// TCP connection is retried and ConnectTimeout is applied to each try
+func (ms *MinSSH) dialTCP(conf *Config) (c net.Conn, err error) {
+	attempts := conf.ConnectionAttempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+
+	wait := initialRetryWait
+	for i := 1; ; i++ {
+		c, err = net.DialTimeout("tcp", conf.hostport(), conf.ConnectTimeout)
+		if err == nil {
+			return c, nil
+		}
+		ms.conf.Logger.Printf("connection attempt %d/%d to %s failed: %s\n", i, attempts, conf.hostport(), err)
+		if i >= attempts {
+			return nil, err
+		}
+
+		ms.conf.Logger.Printf("retrying to connect to %s in %s\n", conf.hostport(), wait)
+		time.Sleep(wait)
+		if wait *= 2; wait > maxRetryWait {
+			wait = maxRetryWait
+		}
+	}
+}
+
+// dialThrough connects to addr through the client and runs ssh handshake
+// over it. timeout is applied to the connection through the client as well
+// as to the handshake
+func dialThrough(client *ssh.Client, addr string, config *ssh.ClientConfig, timeout time.Duration) (*ssh.Client, error) {
+	if timeout <= 0 {
+		c, err := client.Dial("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return newClient(c, addr, config, timeout)
+	}
+
+	type dialResult struct {
+		c   net.Conn
+		err error
+	}
+	resultC := make(chan dialResult, 1)
+	go func() {
+		c, err := client.Dial("tcp", addr)
+		resultC <- dialResult{c, err}
+	}()
+
+	select {
+	case r := <-resultC:
+		if r.err != nil {
+			return nil, r.err
+		}
+		return newClient(r.c, addr, config, timeout)
+	case <-time.After(timeout):
+		// close the connection if it is made too late
+		go func() {
+			if r := <-resultC; r.c != nil {
+				r.c.Close()
+			}
+		}()
+		return nil, fmt.Errorf("connection to %s timed out", addr)
+	}
+}
+
+// newClient runs ssh handshake over c. like OpenSSH, timeout limits time
+// until the server's host key is received, not authentication because it
+// may include asking passwords. c is closed if the handshake fails
+func newClient(c net.Conn, addr string, config *ssh.ClientConfig, timeout time.Duration) (*ssh.Client, error) {
+	timedOutC := make(chan struct{})
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			close(timedOutC)
+			c.Close()
+		})
+		defer timer.Stop()
+
+		hostKeyCallback := config.HostKeyCallback
+		timedConfig := *config
+		timedConfig.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if !timer.Stop() {
+				return fmt.Errorf("ssh handshake timed out")
+			}
+			return hostKeyCallback(hostname, remote, key)
+		}
+		config = &timedConfig
+	}
+
+	conn, chans, reqs, err := ssh.NewClientConn(c, addr, config)
+	if err != nil {
+		c.Close()
+		select {
+		case <-timedOutC:
+			return nil, fmt.Errorf("ssh handshake with %s timed out", addr)
+		default:
+		}
+		return nil, err
+	}
+
+	return ssh.NewClient(conn, chans, reqs), nil
+}
+
+// jumpHostConfig makes a Config for a jump host given like
+// "[user@]host[:port]". it is resolved by the same config files and shares
+// the identity and known_hosts files, connection limits and algorithms with
+// the destination unless they are set for it
+func (ms *MinSSH) jumpHostConfig(spec string) (*Config, error) {
+	spec = strings.TrimPrefix(strings.TrimSpace(spec), "ssh://")
+	if spec == "" {
+		return nil, fmt.Errorf("empty jump host specification")
+	}
+
+	hop := NewConfig()
+	hop.Logger = ms.conf.Logger
+	hop.KnownHostsFiles = ms.conf.KnownHostsFiles
+
+	if i := strings.LastIndex(spec, "@"); i != -1 {
+		if err := hop.SetOption("User", spec[:i]); err != nil {
+			return nil, err
+		}
+		spec = spec[i+1:]
+	}
+	if host, port, err := net.SplitHostPort(spec); err == nil {
+		if err = hop.SetOption("Port", port); err != nil {
+			return nil, fmt.Errorf("bad jump host %q: %s", spec, err)
+		}
+		hop.Host = host
+	} else {
+		hop.Host = strings.TrimSuffix(strings.TrimPrefix(spec, "["), "]")
+	}
+
+	alias := hop.Host
+	for _, sshConf := range ms.conf.SSHConfigs {
+		if err := sshConf.Apply(hop, alias); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(hop.IdentityFiles) == 0 {
+		hop.IdentityFiles = ms.conf.IdentityFiles
+	}
+	if hop.ConnectTimeout == 0 {
+		hop.ConnectTimeout = ms.conf.ConnectTimeout
+	}
+	if hop.ConnectionAttempts == 0 {
+		hop.ConnectionAttempts = ms.conf.ConnectionAttempts
+	}
+	if hop.Ciphers == nil {
+		hop.Ciphers = ms.conf.Ciphers
+	}
+	if hop.MACs == nil {
+		hop.MACs = ms.conf.MACs
+	}
+	if hop.KexAlgorithms == nil {
+		hop.KexAlgorithms = ms.conf.KexAlgorithms
+	}
+	if hop.HostKeyAlgorithms == nil {
+		hop.HostKeyAlgorithms = ms.conf.HostKeyAlgorithms
+	}
+
+	return hop, nil
+}
@@ -0,0 +1,111 @@
+package minssh
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// startHungServer accepts TCP connections and never sends anything
+func startHungServer(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, c := range conns {
+				c.Close()
+			}
+		}()
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, c)
+		}
+	}()
+	return l
+}
+
+func TestConnectTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hung := startHungServer(t)
+	defer hung.Close()
+	hungAddr := hung.Addr().(*net.TCPAddr)
+
+	jump, hostKey, _ := startTestServer(t, testServerOptions{directTCPIP: true})
+	defer jump.Close()
+
+	tests := []struct {
+		name   string
+		config func(conf *Config) error
+	}{
+		{
+			name: "direct",
+			config: func(conf *Config) error {
+				return nil
+			},
+		},
+		{
+			name: "jump host",
+			config: func(conf *Config) error {
+				return conf.SetOption("ProxyJump", "alice@"+jump.Addr().String())
+			},
+		},
+		{
+			name: "jump host to hung jump host",
+			config: func(conf *Config) error {
+				return conf.SetOption("ProxyJump", "alice@"+jump.Addr().String()+",alice@"+hungAddr.String())
+			},
+		},
+		{
+			name: "proxy command",
+			config: func(conf *Config) error {
+				if runtime.GOOS == "windows" {
+					return conf.SetOption("ProxyCommand", "ping -n 60 127.0.0.1 >NUL")
+				}
+				return conf.SetOption("ProxyCommand", "exec sleep 60")
+			},
+		},
+	}
+
+	const timeout = 200 * time.Millisecond
+	for _, tt := range tests {
+		conf := newTestClientConfig(t, dir, jump, hostKey)
+		conf.Host = hungAddr.IP.String()
+		conf.Port = hungAddr.Port
+		conf.ConnectTimeout = timeout
+		if err = tt.config(conf); err != nil {
+			t.Fatal(err)
+		}
+
+		ms := &MinSSH{conf: conf, sys: &sysInfo{}, fileSigners: make(map[string]ssh.Signer)}
+		errC := make(chan error, 1)
+		go func() {
+			errC <- ms.dial()
+		}()
+		select {
+		case err = <-errC:
+			if err == nil {
+				t.Errorf("%s: connected to the hung server", tt.name)
+			}
+		case <-time.After(20 * timeout):
+			t.Errorf("%s: ConnectTimeout %s didn't stop connecting to the hung server", tt.name, timeout)
+			continue
+		}
+		ms.Close()
+	}
+}
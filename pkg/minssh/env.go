@@ -0,0 +1,65 @@
+package minssh
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sendEnv sends environment variables given by SetEnv and the local ones
+// matching SendEnv patterns. servers may refuse them by their configuration
+// like OpenSSH's AcceptEnv so that refusals are just logged
+func (ms *MinSSH) sendEnv(sess requestSender) {
+	sent := make(map[string]bool)
+
+	// like other options, the first value is used for each variable
+	for _, kv := range ms.conf.SetEnv {
+		i := strings.Index(kv, "=")
+		name := kv[:i]
+		if sent[name] {
+			continue
+		}
+		sent[name] = true
+		ms.setenv(sess, name, kv[i+1:])
+	}
+
+	if len(ms.conf.SendEnv) == 0 {
+		return
+	}
+	for _, kv := range os.Environ() {
+		// Windows has special variables like "=C:"
+		i := strings.Index(kv, "=")
+		if i <= 0 {
+			continue
+		}
+		name := kv[:i]
+		if sent[name] || !matchAnyPattern(ms.conf.SendEnv, name) {
+			continue
+		}
+		sent[name] = true
+		ms.setenv(sess, name, kv[i+1:])
+	}
+}
+
+func (ms *MinSSH) setenv(sess requestSender, name, value string) {
+	ok, err := sess.SendRequest("env", true, ssh.Marshal(struct{ Name, Value string }{name, value}))
+	if err == nil && !ok {
+		err = fmt.Errorf("env request failed")
+	}
+	if err != nil {
+		ms.conf.Logger.Printf("server refused environment variable %s: %s\n", name, err)
+		return
+	}
+	ms.conf.Logger.Printf("sent environment variable %s\n", name)
+}
+
+func matchAnyPattern(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if matchPattern(p, s) {
+			return true
+		}
+	}
+	return false
+}
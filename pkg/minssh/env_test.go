@@ -0,0 +1,174 @@
+package minssh
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// envRecorder is a session recording "env" requests. it refuses variables
+// in refused
+type envRecorder struct {
+	env     []string
+	refused map[string]bool
+}
+
+func (r *envRecorder) SendRequest(name string, wantReply bool, payload []byte) (bool, error) {
+	var msg struct{ Name, Value string }
+	if err := ssh.Unmarshal(payload, &msg); err != nil {
+		return false, err
+	}
+	if name != "env" || r.refused[msg.Name] {
+		return false, nil
+	}
+	r.env = append(r.env, msg.Name+"="+msg.Value)
+	return true, nil
+}
+
+func TestSendEnv(t *testing.T) {
+	environ := map[string]string{
+		"MINSSH_TEST_A":     "local a",
+		"MINSSH_TEST_B":     "local b",
+		"MINSSH_TEST_OTHER": "local other",
+	}
+	for name, value := range environ {
+		if err := os.Setenv(name, value); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Unsetenv(name)
+	}
+
+	tests := []struct {
+		sendEnv []string
+		setEnv  []string
+		refused []string
+		want    []string
+	}{
+		{
+			want: nil,
+		},
+		{
+			sendEnv: []string{"MINSSH_TEST_A"},
+			want:    []string{"MINSSH_TEST_A=local a"},
+		},
+		{
+			sendEnv: []string{"MINSSH_TEST_?", "MINSSH_NO_SUCH_*"},
+			want:    []string{"MINSSH_TEST_A=local a", "MINSSH_TEST_B=local b"},
+		},
+		{
+			sendEnv: []string{"MINSSH_TEST_*"},
+			want:    []string{"MINSSH_TEST_A=local a", "MINSSH_TEST_B=local b", "MINSSH_TEST_OTHER=local other"},
+		},
+		{
+			setEnv: []string{"MINSSH_TEST_A=set a", "MINSSH_TEST_A=ignored", "MINSSH_TEST_C=x=y"},
+			want:   []string{"MINSSH_TEST_A=set a", "MINSSH_TEST_C=x=y"},
+		},
+		{
+			sendEnv: []string{"MINSSH_TEST_?"},
+			setEnv:  []string{"MINSSH_TEST_A=set a"},
+			want:    []string{"MINSSH_TEST_A=set a", "MINSSH_TEST_B=local b"},
+		},
+		{
+			// a refused variable isn't sent again from the local one
+			sendEnv: []string{"MINSSH_TEST_?"},
+			setEnv:  []string{"MINSSH_TEST_A=set a"},
+			refused: []string{"MINSSH_TEST_A"},
+			want:    []string{"MINSSH_TEST_B=local b"},
+		},
+	}
+
+	for _, tt := range tests {
+		conf := NewConfig()
+		conf.SendEnv = tt.sendEnv
+		conf.SetEnv = tt.setEnv
+		ms := &MinSSH{conf: conf}
+
+		r := &envRecorder{refused: make(map[string]bool)}
+		for _, name := range tt.refused {
+			r.refused[name] = true
+		}
+		ms.sendEnv(r)
+
+		got := make(map[string]bool)
+		for _, kv := range r.env {
+			got[kv] = true
+		}
+		want := make(map[string]bool)
+		for _, kv := range tt.want {
+			want[kv] = true
+		}
+		if len(r.env) != len(got) || !reflect.DeepEqual(got, want) {
+			t.Errorf("SendEnv %q and SetEnv %q sent %q, want %q", tt.sendEnv, tt.setEnv, r.env, tt.want)
+		}
+	}
+}
+
+func TestSendEnvOption(t *testing.T) {
+	tests := []struct {
+		values [][]string
+		want   []string
+	}{
+		{
+			values: [][]string{{"LANG", "LC_*"}},
+			want:   []string{"LANG", "LC_*"},
+		},
+		{
+			values: [][]string{{"LANG"}, {"LC_*"}},
+			want:   []string{"LANG", "LC_*"},
+		},
+		{
+			values: [][]string{{"LANG", "LC_*"}, {"-LANG"}},
+			want:   []string{"LC_*"},
+		},
+		{
+			values: [][]string{{"LANG", "LC_*", "-LC_*", "TERM"}},
+			want:   []string{"LANG", "TERM"},
+		},
+		{
+			// removal isn't matched as a pattern
+			values: [][]string{{"LC_ALL", "LC_*"}, {"-LC_*"}},
+			want:   []string{"LC_ALL"},
+		},
+		{
+			values: [][]string{{"LC_*"}, {"-LC_ALL"}},
+			want:   []string{"LC_*"},
+		},
+		{
+			values: [][]string{{"LANG"}, {"-LANG"}},
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		conf := NewConfig()
+		for _, args := range tt.values {
+			if err := conf.SetOption("SendEnv", args...); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !reflect.DeepEqual(conf.SendEnv, tt.want) {
+			t.Errorf("SendEnv %q = %q, want %q", tt.values, conf.SendEnv, tt.want)
+		}
+	}
+}
+
+func TestSetEnvOption(t *testing.T) {
+	conf := NewConfig()
+	if err := conf.SetOption("SetEnv", "A=1", "B="); err != nil {
+		t.Fatal(err)
+	}
+	if err := conf.SetOption("SetEnv", "A=2"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"A=1", "B=", "A=2"}; !reflect.DeepEqual(conf.SetEnv, want) {
+		t.Errorf("SetEnv = %q, want %q", conf.SetEnv, want)
+	}
+
+	for _, kv := range []string{"A", "=1"} {
+		if err := NewConfig().SetOption("SetEnv", kv); err == nil {
+			t.Errorf("SetEnv %q returned no error", kv)
+		}
+	}
+}
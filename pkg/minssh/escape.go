@@ -0,0 +1,216 @@
+package minssh
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	keyCtrlC     byte = 0x03
+	keyBackspace byte = 0x08
+	keyCtrlU     byte = 0x15
+	keyCtrlZ     byte = 0x1a
+	keyDelete    byte = 0x7f
+)
+
+// escapeState keeps the state of OpenSSH style escape sequences which are
+// recognized only at the beginning of a line
+type escapeState struct {
+	atLineStart bool
+	pending     bool
+	// cmdline is a line typed after "~C". it is nil if it isn't reading
+	cmdline []byte
+}
+
+// newEscapeState returns a state to read escape sequences from stdin. like
+// OpenSSH, they are available only with a pty. it returns nil if they are
+// disabled by "none"
+func (ms *MinSSH) newEscapeState() *escapeState {
+	if ms.conf.EscapeChar == 0 || ms.conf.NoTTY {
+		return nil
+	}
+	return &escapeState{atLineStart: true}
+}
+
+func parseEscapeChar(s string) (byte, error) {
+	switch {
+	case s == "none":
+		return 0, nil
+	case len(s) == 1:
+		return s[0], nil
+	case len(s) == 2 && s[0] == '^':
+		return s[1] & 0x1f, nil
+	}
+	return 0, fmt.Errorf("bad escape character %q", s)
+}
+
+func escapeCharString(c byte) string {
+	if c < 0x20 {
+		return "^" + string(c|0x40)
+	}
+	return string(c)
+}
+
+// filterEscape handles escape sequences in b and returns bytes to be sent to
+// remote. quit is true when the connection should be terminated
+func (ms *MinSSH) filterEscape(es *escapeState, b []byte) (out []byte, quit bool) {
+	esc := ms.conf.EscapeChar
+	for _, c := range b {
+		if es.cmdline != nil {
+			ms.readEscapeCommandLine(es, c)
+			continue
+		}
+
+		if es.pending {
+			es.pending = false
+			es.atLineStart = true
+			switch c {
+			case '.':
+				fmt.Fprintf(os.Stderr, "%s.\r\n", escapeCharString(esc))
+				return out, true
+			case keyCtrlZ:
+				fmt.Fprintf(os.Stderr, "%s^Z [suspend ssh]\r\n", escapeCharString(esc))
+				if err := ms.suspend(); err != nil {
+					fmt.Fprintf(os.Stderr, "%s\r\n", err)
+				}
+			case '#':
+				fmt.Fprintf(os.Stderr, "%s#\r\n", escapeCharString(esc))
+				ms.listForwards(os.Stderr)
+			case 'C':
+				fmt.Fprint(os.Stderr, "\r\nssh> ")
+				es.cmdline = []byte{}
+			case '?':
+				fmt.Fprintf(os.Stderr, "%s?\r\n", escapeCharString(esc))
+				ms.printEscapeHelp()
+			case esc:
+				out = append(out, esc)
+				es.atLineStart = false
+			default:
+				out = append(out, esc, c)
+				es.atLineStart = c == '\r' || c == '\n'
+			}
+			continue
+		}
+
+		if es.atLineStart && c == esc {
+			es.pending = true
+			continue
+		}
+		out = append(out, c)
+		es.atLineStart = c == '\r' || c == '\n'
+	}
+	return out, false
+}
+
+// readEscapeCommandLine edits the command line by c and runs it when enter
+// is typed. the terminal is in raw mode so that it echoes by itself
+func (ms *MinSSH) readEscapeCommandLine(es *escapeState, c byte) {
+	switch c {
+	case '\r', '\n':
+		fmt.Fprint(os.Stderr, "\r\n")
+		if err := ms.runEscapeCommand(string(es.cmdline)); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\r\n", err)
+		}
+		es.cmdline = nil
+	case keyBackspace, keyDelete:
+		if len(es.cmdline) > 0 {
+			es.cmdline = es.cmdline[:len(es.cmdline)-1]
+			fmt.Fprint(os.Stderr, "\b \b")
+		}
+	case keyCtrlC, keyCtrlU:
+		fmt.Fprint(os.Stderr, "\r\n")
+		es.cmdline = nil
+	default:
+		if c >= 0x20 {
+			es.cmdline = append(es.cmdline, c)
+			fmt.Fprintf(os.Stderr, "%c", c)
+		}
+	}
+}
+
+// runEscapeCommand runs a command typed after "~C" to add or cancel port
+// forwardings like OpenSSH
+func (ms *MinSSH) runEscapeCommand(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil
+	}
+	if line == "?" || line == "help" || line == "-h" {
+		fmt.Fprint(os.Stderr, "Commands:\r\n"+
+			"      -L[bind_address:]port:host:hostport    Request local forward\r\n"+
+			"      -R[bind_address:]port:host:hostport    Request remote forward\r\n"+
+			"      -D[bind_address:]port                  Request dynamic forward\r\n"+
+			"      -KL[bind_address:]port                 Cancel local forward\r\n"+
+			"      -KR[bind_address:]port                 Cancel remote forward\r\n"+
+			"      -KD[bind_address:]port                 Cancel dynamic forward\r\n")
+		return nil
+	}
+
+	var cmd, spec string
+	for _, c := range []string{"-KL", "-KR", "-KD", "-L", "-R", "-D"} {
+		if strings.HasPrefix(line, c) {
+			cmd, spec = c, strings.TrimSpace(line[len(c):])
+			break
+		}
+	}
+
+	switch cmd {
+	case "-L", "-R":
+		f, err := ParseForward(spec)
+		if err != nil {
+			return err
+		}
+		if cmd == "-L" {
+			err = ms.AddLocalForward(f)
+		} else {
+			err = ms.AddRemoteForward(f)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(os.Stderr, "Forwarding port.\r\n")
+	case "-D":
+		f, err := ParseDynamicForward(spec)
+		if err != nil {
+			return err
+		}
+		if err = ms.AddDynamicForward(f); err != nil {
+			return err
+		}
+		fmt.Fprint(os.Stderr, "Forwarding port.\r\n")
+	case "-KL", "-KR", "-KD":
+		// cancellation needs only "[bind_address:]port"
+		f, err := ParseDynamicForward(spec)
+		if err != nil {
+			return err
+		}
+		switch cmd {
+		case "-KL":
+			err = ms.CancelLocalForward(f)
+		case "-KR":
+			err = ms.CancelRemoteForward(f)
+		case "-KD":
+			err = ms.CancelDynamicForward(f)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(os.Stderr, "Canceled forwarding.\r\n")
+	default:
+		return fmt.Errorf("invalid command %q. type '?' to show commands", line)
+	}
+	return nil
+}
+
+func (ms *MinSSH) printEscapeHelp() {
+	esc := escapeCharString(ms.conf.EscapeChar)
+	fmt.Fprintf(os.Stderr, "Supported escape sequences:\r\n"+
+		" %[1]s.   - terminate connection\r\n"+
+		" %[1]sC   - open a command line\r\n"+
+		" %[1]s#   - list forwarded connections\r\n"+
+		" %[1]s^Z  - suspend ssh\r\n"+
+		" %[1]s?   - this message\r\n"+
+		" %[1]s%[1]s   - send the escape character by typing it twice\r\n"+
+		"(Note that escapes are only recognized immediately after newline.)\r\n", esc)
+}
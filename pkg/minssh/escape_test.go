@@ -0,0 +1,205 @@
+package minssh
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// discardStderr discards messages which escape sequences print to stderr
+// until the returned function is called
+func discardStderr(t *testing.T) func() {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = devNull
+	return func() {
+		os.Stderr = stderr
+		devNull.Close()
+	}
+}
+
+func TestParseEscapeChar(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    byte
+		wantErr bool
+	}{
+		{s: "~", want: '~'},
+		{s: "!", want: '!'},
+		{s: "^]", want: 0x1d},
+		{s: "^a", want: 0x01},
+		{s: "none", want: 0},
+		{s: "", wantErr: true},
+		{s: "ab", wantErr: true},
+		{s: "^ab", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseEscapeChar(tt.s)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseEscapeChar(%q) returned no error", tt.s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseEscapeChar(%q) returned error: %s", tt.s, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseEscapeChar(%q) = %#x, want %#x", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestNewEscapeState(t *testing.T) {
+	tests := []struct {
+		escapeChar string
+		noTTY      bool
+		want       bool
+	}{
+		{escapeChar: "~", want: true},
+		{escapeChar: "^]", want: true},
+		{escapeChar: "none", want: false},
+		{escapeChar: "~", noTTY: true, want: false},
+	}
+
+	for _, tt := range tests {
+		conf := NewConfig()
+		if err := conf.SetOption("EscapeChar", tt.escapeChar); err != nil {
+			t.Fatal(err)
+		}
+		conf.NoTTY = tt.noTTY
+		ms := &MinSSH{conf: conf}
+		if got := ms.newEscapeState() != nil; got != tt.want {
+			t.Errorf("escape sequences with EscapeChar %q and NoTTY %v are enabled: %v, want %v",
+				tt.escapeChar, tt.noTTY, got, tt.want)
+		}
+	}
+}
+
+func TestFilterEscape(t *testing.T) {
+	tests := []struct {
+		esc      byte
+		input    []string
+		want     string
+		wantQuit bool
+	}{
+		{esc: '~', input: []string{"ls\r"}, want: "ls\r"},
+		{esc: '~', input: []string{"~."}, want: "", wantQuit: true},
+		{esc: '~', input: []string{"ls\r~."}, want: "ls\r", wantQuit: true},
+		{esc: '~', input: []string{"ls\n~."}, want: "ls\n", wantQuit: true},
+		{esc: '~', input: []string{"ls\r", "~", "."}, want: "ls\r", wantQuit: true},
+		// escape characters not at the beginning of a line are sent as is
+		{esc: '~', input: []string{"a~."}, want: "a~."},
+		{esc: '~', input: []string{"a", "~."}, want: "a~."},
+		// typing it twice sends one and the line doesn't start any more
+		{esc: '~', input: []string{"~~"}, want: "~"},
+		{esc: '~', input: []string{"~~."}, want: "~."},
+		{esc: '~', input: []string{"~", "~", "~."}, want: "~~."},
+		// unknown sequences are sent with the escape character
+		{esc: '~', input: []string{"~x"}, want: "~x"},
+		{esc: '~', input: []string{"~\r~."}, want: "~\r", wantQuit: true},
+		// custom escape characters
+		{esc: '!', input: []string{"~."}, want: "~."},
+		{esc: '!', input: []string{"!."}, want: "", wantQuit: true},
+		{esc: '!', input: []string{"!!"}, want: "!"},
+		{esc: 0x1d, input: []string{"ls\r\x1d."}, want: "ls\r", wantQuit: true},
+	}
+
+	defer discardStderr(t)()
+
+	for _, tt := range tests {
+		conf := NewConfig()
+		conf.EscapeChar = tt.esc
+		ms := &MinSSH{conf: conf}
+		es := ms.newEscapeState()
+
+		var (
+			got  []byte
+			quit bool
+		)
+		for _, s := range tt.input {
+			var out []byte
+			out, quit = ms.filterEscape(es, []byte(s))
+			got = append(got, out...)
+			if quit {
+				break
+			}
+		}
+		if string(got) != tt.want || quit != tt.wantQuit {
+			t.Errorf("filterEscape of %q with %q = %q, %v, want %q, %v",
+				tt.input, escapeCharString(tt.esc), got, quit, tt.want, tt.wantQuit)
+		}
+	}
+}
+
+func TestRunEscapeCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the server accepts remote forwardings without listening
+	reqs := make(chan *ssh.Request)
+	l, hostKey, _ := startTestServer(t, testServerOptions{requests: reqs})
+	defer l.Close()
+	go func() {
+		for req := range reqs {
+			req.Reply(true, nil)
+		}
+	}()
+
+	conf := newTestClientConfig(t, dir, l, hostKey)
+	ms, err := Open(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ms.Close()
+	defer discardStderr(t)()
+
+	tests := []struct {
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{line: "", want: nil},
+		{line: "-L127.0.0.1:0:localhost:80", want: []string{"local 127.0.0.1:0:localhost:80"}},
+		{line: "-R 8022:localhost:80", want: []string{"local 127.0.0.1:0:localhost:80", "remote 8022:localhost:80"}},
+		{line: "  -D127.0.0.1:0  ", want: []string{"local 127.0.0.1:0:localhost:80", "remote 8022:localhost:80", "dynamic 127.0.0.1:0"}},
+		{line: "-KL127.0.0.1:0", want: []string{"remote 8022:localhost:80", "dynamic 127.0.0.1:0"}},
+		{line: "-KL127.0.0.1:0", want: []string{"remote 8022:localhost:80", "dynamic 127.0.0.1:0"}, wantErr: true},
+		{line: "-KR localhost:8022", want: []string{"dynamic 127.0.0.1:0"}},
+		{line: "-KD127.0.0.1:0", want: nil},
+		{line: "-L8080", want: nil, wantErr: true},
+		{line: "-R", want: nil, wantErr: true},
+		{line: "-D1:2:3", want: nil, wantErr: true},
+		{line: "-KL8080:localhost:80", want: nil, wantErr: true},
+		{line: "-X8080", want: nil, wantErr: true},
+		{line: "ls", want: nil, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		err := ms.runEscapeCommand(tt.line)
+		if tt.wantErr && err == nil {
+			t.Errorf("runEscapeCommand(%q) returned no error", tt.line)
+		} else if !tt.wantErr && err != nil {
+			t.Errorf("runEscapeCommand(%q) returned error: %s", tt.line, err)
+		}
+
+		var got []string
+		for _, fl := range ms.fwdListeners {
+			got = append(got, fl.kind+" "+fl.fwd.String())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("forwardings after runEscapeCommand(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
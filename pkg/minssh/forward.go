@@ -0,0 +1,356 @@
+package minssh
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Forward is a port forwarding specification given like
+// "[bind_address:]port:host:hostport"
+type Forward struct {
+	BindAddress string
+	BindPort    int
+	Host        string
+	HostPort    int
+}
+
+func ParseForward(spec string) (*Forward, error) {
+	fields, err := splitForwardSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Forward{}
+	switch len(fields) {
+	case 3:
+	case 4:
+		f.BindAddress = fields[0]
+		if f.BindAddress == "" {
+			f.BindAddress = "*"
+		}
+		fields = fields[1:]
+	default:
+		return nil, fmt.Errorf("bad forwarding specification %q", spec)
+	}
+
+	if f.BindPort, err = parseForwardPort(fields[0]); err != nil {
+		return nil, fmt.Errorf("bad forwarding specification %q: %s", spec, err)
+	}
+	f.Host = fields[1]
+	if f.Host == "" {
+		return nil, fmt.Errorf("bad forwarding specification %q: missing host", spec)
+	}
+	if f.HostPort, err = parseForwardPort(fields[2]); err != nil {
+		return nil, fmt.Errorf("bad forwarding specification %q: %s", spec, err)
+	}
+
+	return f, nil
+}
+
+// splitForwardSpec splits spec by ":" except ones in IPv6 addresses enclosed
+// by brackets
+func splitForwardSpec(spec string) (fields []string, err error) {
+	var (
+		buf       []rune
+		inBracket bool
+	)
+	for _, r := range spec {
+		switch {
+		case r == '[' && !inBracket:
+			inBracket = true
+		case r == ']' && inBracket:
+			inBracket = false
+		case r == ':' && !inBracket:
+			fields = append(fields, string(buf))
+			buf = buf[:0]
+		default:
+			buf = append(buf, r)
+		}
+	}
+	if inBracket {
+		return nil, fmt.Errorf("bad forwarding specification %q: unterminated bracket", spec)
+	}
+	return append(fields, string(buf)), nil
+}
+
+func parseForwardPort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 0 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return port, nil
+}
+
+func (f *Forward) String() string {
+	s := strconv.Itoa(f.BindPort)
+	if f.BindAddress != "" {
+		s = joinForwardHost(f.BindAddress) + ":" + s
+	}
+	if f.Host != "" {
+		s += ":" + joinForwardHost(f.Host) + ":" + strconv.Itoa(f.HostPort)
+	}
+	return s
+}
+
+func joinForwardHost(host string) string {
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// ListenAddr returns an address to listen. if BindAddress isn't set, it
+// listens only loopback address. "*" means all interfaces
+func (f *Forward) ListenAddr() string {
+	bind := f.BindAddress
+	switch bind {
+	case "":
+		bind = "localhost"
+	case "*":
+		bind = ""
+	}
+	return net.JoinHostPort(bind, strconv.Itoa(f.BindPort))
+}
+
+// bindHost returns BindAddress with its default resolved. an empty one is
+// the loopback address and "*" is "0.0.0.0"
+func (f *Forward) bindHost() string {
+	switch f.BindAddress {
+	case "":
+		return "localhost"
+	case "*":
+		return "0.0.0.0"
+	}
+	return f.BindAddress
+}
+
+// remoteListenAddr returns an address requested to the server to listen by
+// remote forwarding. "*" is sent as "0.0.0.0" because the server resolves it
+func (f *Forward) remoteListenAddr() string {
+	return net.JoinHostPort(f.bindHost(), strconv.Itoa(f.BindPort))
+}
+
+func (f *Forward) ConnectAddr() string {
+	return net.JoinHostPort(f.Host, strconv.Itoa(f.HostPort))
+}
+
+type forwardListener struct {
+	kind string
+	fwd  *Forward
+	net.Listener
+}
+
+// forwardConn is an open connection through a forwarding
+type forwardConn struct {
+	kind string
+	from string
+	to   string
+}
+
+func (ms *MinSSH) startForwards() {
+	for _, f := range ms.conf.LocalForwards {
+		if err := ms.AddLocalForward(f); err != nil {
+			ms.printInfo("Warning: %s\n", err)
+		}
+	}
+	for _, f := range ms.conf.RemoteForwards {
+		if err := ms.AddRemoteForward(f); err != nil {
+			ms.printInfo("Warning: %s\n", err)
+		}
+	}
+	for _, f := range ms.conf.DynamicForwards {
+		if err := ms.AddDynamicForward(f); err != nil {
+			ms.printInfo("Warning: %s\n", err)
+		}
+	}
+}
+
+func (ms *MinSSH) AddLocalForward(f *Forward) error {
+	l, err := net.Listen("tcp", f.ListenAddr())
+	if err != nil {
+		return fmt.Errorf("could not request local forwarding %s: %s", f, err)
+	}
+	ms.conf.Logger.Printf("local forwarding listens on %s to %s\n", l.Addr(), f.ConnectAddr())
+
+	ms.addForwardListener(&forwardListener{kind: "local", fwd: f, Listener: l})
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				ms.conf.Logger.Printf("local forwarding on %s stopped: %s\n", l.Addr(), err)
+				return
+			}
+			go ms.forwardLocalConn(c, f)
+		}
+	}()
+
+	return nil
+}
+
+func (ms *MinSSH) forwardLocalConn(c net.Conn, f *Forward) {
+	defer c.Close()
+
+	ms.conf.Logger.Printf("local forwarding: connection from %s to %s\n", c.RemoteAddr(), f.ConnectAddr())
+	rc, err := ms.conn.Dial("tcp", f.ConnectAddr())
+	if err != nil {
+		ms.conf.Logger.Printf("local forwarding: failed to connect to %s: %s\n", f.ConnectAddr(), err)
+		return
+	}
+	defer rc.Close()
+	defer ms.trackForwardConn("local", c.RemoteAddr().String(), f.ConnectAddr())()
+
+	relay(c, rc)
+	ms.conf.Logger.Printf("local forwarding: connection from %s to %s closed\n", c.RemoteAddr(), f.ConnectAddr())
+}
+
+// AddRemoteForward requests the server to listen a port by "tcpip-forward"
+// global request and forwards connections to it to the local side. if the
+// port is 0, the server allocates a port and it is printed
+func (ms *MinSSH) AddRemoteForward(f *Forward) error {
+	l, err := ms.conn.Listen("tcp", f.remoteListenAddr())
+	if err != nil {
+		return fmt.Errorf("could not request remote forwarding %s: %s", f, err)
+	}
+	if f.BindPort == 0 {
+		ms.printInfo("Allocated port %d for remote forward to %s\n", l.Addr().(*net.TCPAddr).Port, f.ConnectAddr())
+	}
+	ms.conf.Logger.Printf("remote forwarding listens on %s to %s\n", l.Addr(), f.ConnectAddr())
+
+	ms.addForwardListener(&forwardListener{kind: "remote", fwd: f, Listener: l})
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				ms.conf.Logger.Printf("remote forwarding on %s stopped: %s\n", l.Addr(), err)
+				return
+			}
+			go ms.forwardRemoteConn(c, f)
+		}
+	}()
+
+	return nil
+}
+
+func (ms *MinSSH) forwardRemoteConn(c net.Conn, f *Forward) {
+	defer c.Close()
+
+	ms.conf.Logger.Printf("remote forwarding: connection from %s to %s\n", c.RemoteAddr(), f.ConnectAddr())
+	lc, err := net.Dial("tcp", f.ConnectAddr())
+	if err != nil {
+		ms.conf.Logger.Printf("remote forwarding: failed to connect to %s: %s\n", f.ConnectAddr(), err)
+		return
+	}
+	defer lc.Close()
+	defer ms.trackForwardConn("remote", c.RemoteAddr().String(), f.ConnectAddr())()
+
+	relay(c, lc)
+	ms.conf.Logger.Printf("remote forwarding: connection from %s to %s closed\n", c.RemoteAddr(), f.ConnectAddr())
+}
+
+func (ms *MinSSH) addForwardListener(fl *forwardListener) {
+	ms.fwdMu.Lock()
+	defer ms.fwdMu.Unlock()
+	ms.fwdListeners = append(ms.fwdListeners, fl)
+}
+
+// cancelForward stops the forwarding listening the same address as f. bind
+// addresses are compared after resolving their defaults so that e.g.
+// "localhost:8080" cancels a forwarding given as "8080"
+func (ms *MinSSH) cancelForward(kind string, f *Forward) error {
+	ms.fwdMu.Lock()
+	defer ms.fwdMu.Unlock()
+	for i, fl := range ms.fwdListeners {
+		if fl.kind != kind || fl.fwd.BindPort != f.BindPort || !strings.EqualFold(fl.fwd.bindHost(), f.bindHost()) {
+			continue
+		}
+		ms.fwdListeners = append(ms.fwdListeners[:i], ms.fwdListeners[i+1:]...)
+		if err := fl.Close(); err != nil {
+			return fmt.Errorf("failed to cancel %s forwarding %s: %s", kind, fl.fwd, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown %s forwarding %s", kind, f)
+}
+
+func (ms *MinSSH) CancelLocalForward(f *Forward) error {
+	return ms.cancelForward("local", f)
+}
+
+func (ms *MinSSH) CancelRemoteForward(f *Forward) error {
+	return ms.cancelForward("remote", f)
+}
+
+func (ms *MinSSH) CancelDynamicForward(f *Forward) error {
+	return ms.cancelForward("dynamic", f)
+}
+
+// trackForwardConn records an open connection to list it by "~#" escape
+// sequence. returned function removes it
+func (ms *MinSSH) trackForwardConn(kind, from, to string) func() {
+	fc := &forwardConn{kind: kind, from: from, to: to}
+
+	ms.fwdMu.Lock()
+	defer ms.fwdMu.Unlock()
+	if ms.fwdConns == nil {
+		ms.fwdConns = make(map[*forwardConn]struct{})
+	}
+	ms.fwdConns[fc] = struct{}{}
+
+	return func() {
+		ms.fwdMu.Lock()
+		defer ms.fwdMu.Unlock()
+		delete(ms.fwdConns, fc)
+	}
+}
+
+func (ms *MinSSH) listForwards(w io.Writer) {
+	ms.fwdMu.Lock()
+	defer ms.fwdMu.Unlock()
+
+	fmt.Fprintf(w, "The following forwardings are listening:\r\n")
+	for _, fl := range ms.fwdListeners {
+		fmt.Fprintf(w, "  %s forwarding %s\r\n", fl.kind, fl.fwd)
+	}
+	fmt.Fprintf(w, "The following connections are open:\r\n")
+	for fc := range ms.fwdConns {
+		fmt.Fprintf(w, "  %s forwarding from %s to %s\r\n", fc.kind, fc.from, fc.to)
+	}
+}
+
+func (ms *MinSSH) closeForwards() {
+	ms.fwdMu.Lock()
+	defer ms.fwdMu.Unlock()
+	for _, fl := range ms.fwdListeners {
+		if err := fl.Close(); err != nil {
+			ms.conf.Logger.Printf("failed to close %s forwarding %s: %s\n", fl.kind, fl.fwd, err)
+		}
+	}
+	ms.fwdListeners = nil
+}
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// relay copies data between a and b in both directions until both sides
+// finish sending
+func relay(a, b io.ReadWriter) {
+	var wg sync.WaitGroup
+	cp := func(dst, src io.ReadWriter) {
+		defer wg.Done()
+		io.Copy(dst, src)
+		if cw, ok := dst.(closeWriter); ok {
+			cw.CloseWrite()
+		}
+	}
+	wg.Add(2)
+	go cp(a, b)
+	go cp(b, a)
+	wg.Wait()
+}
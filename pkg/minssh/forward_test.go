@@ -0,0 +1,276 @@
+package minssh
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestSplitForwardSpec(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []string
+		wantErr bool
+	}{
+		{spec: "8080", want: []string{"8080"}},
+		{spec: "8080:localhost:80", want: []string{"8080", "localhost", "80"}},
+		{spec: ":8080:localhost:80", want: []string{"", "8080", "localhost", "80"}},
+		{spec: "8080:[::1]:80", want: []string{"8080", "::1", "80"}},
+		{spec: "[fe80::1%eth0]:8080:[2001:db8::1]:80", want: []string{"fe80::1%eth0", "8080", "2001:db8::1", "80"}},
+		{spec: "8080::80", want: []string{"8080", "", "80"}},
+		{spec: "8080:[::1:80", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := splitForwardSpec(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("splitForwardSpec(%q) returned no error", tt.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("splitForwardSpec(%q) returned error: %s", tt.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitForwardSpec(%q) = %q, want %q", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseForward(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Forward
+		wantErr bool
+	}{
+		{
+			spec: "8080:localhost:80",
+			want: Forward{BindPort: 8080, Host: "localhost", HostPort: 80},
+		},
+		{
+			spec: "127.0.0.1:8080:example.com:80",
+			want: Forward{BindAddress: "127.0.0.1", BindPort: 8080, Host: "example.com", HostPort: 80},
+		},
+		{
+			spec: ":8080:localhost:80",
+			want: Forward{BindAddress: "*", BindPort: 8080, Host: "localhost", HostPort: 80},
+		},
+		{
+			spec: "*:8080:localhost:80",
+			want: Forward{BindAddress: "*", BindPort: 8080, Host: "localhost", HostPort: 80},
+		},
+		{
+			spec: "[::1]:8080:[2001:db8::1]:80",
+			want: Forward{BindAddress: "::1", BindPort: 8080, Host: "2001:db8::1", HostPort: 80},
+		},
+		{
+			spec: "0:localhost:80",
+			want: Forward{BindPort: 0, Host: "localhost", HostPort: 80},
+		},
+		{spec: "8080", wantErr: true},
+		{spec: "8080:localhost", wantErr: true},
+		{spec: "a:b:8080:localhost:80", wantErr: true},
+		{spec: "8080::80", wantErr: true},
+		{spec: "http:localhost:80", wantErr: true},
+		{spec: "8080:localhost:65536", wantErr: true},
+		{spec: "-1:localhost:80", wantErr: true},
+		{spec: "8080:[::1:80", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseForward(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseForward(%q) returned no error", tt.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseForward(%q) returned error: %s", tt.spec, err)
+			continue
+		}
+		if *got != tt.want {
+			t.Errorf("ParseForward(%q) = %+v, want %+v", tt.spec, *got, tt.want)
+		}
+	}
+}
+
+func TestForwardAddrs(t *testing.T) {
+	tests := []struct {
+		fwd     Forward
+		str     string
+		listen  string
+		connect string
+	}{
+		{
+			fwd:     Forward{BindPort: 8080, Host: "localhost", HostPort: 80},
+			str:     "8080:localhost:80",
+			listen:  "localhost:8080",
+			connect: "localhost:80",
+		},
+		{
+			fwd:     Forward{BindAddress: "*", BindPort: 8080, Host: "example.com", HostPort: 80},
+			str:     "*:8080:example.com:80",
+			listen:  ":8080",
+			connect: "example.com:80",
+		},
+		{
+			fwd:     Forward{BindAddress: "::1", BindPort: 8080, Host: "2001:db8::1", HostPort: 80},
+			str:     "[::1]:8080:[2001:db8::1]:80",
+			listen:  "[::1]:8080",
+			connect: "[2001:db8::1]:80",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := tt.fwd.String(); got != tt.str {
+			t.Errorf("String() of %+v = %q, want %q", tt.fwd, got, tt.str)
+		}
+		if got := tt.fwd.ListenAddr(); got != tt.listen {
+			t.Errorf("ListenAddr() of %+v = %q, want %q", tt.fwd, got, tt.listen)
+		}
+		if got := tt.fwd.ConnectAddr(); got != tt.connect {
+			t.Errorf("ConnectAddr() of %+v = %q, want %q", tt.fwd, got, tt.connect)
+		}
+
+		// the string form is parsed back to the same forwarding
+		f, err := ParseForward(tt.str)
+		if err != nil {
+			t.Errorf("ParseForward(%q) returned error: %s", tt.str, err)
+		} else if *f != tt.fwd {
+			t.Errorf("ParseForward(%q) = %+v, want %+v", tt.str, *f, tt.fwd)
+		}
+	}
+}
+
+func TestLocalForwardOption(t *testing.T) {
+	conf := NewConfig()
+	for _, args := range [][]string{
+		{"8080", "localhost:80"},
+		{"[::1]:8081", "[::1]:81"},
+	} {
+		if err := conf.SetOption("LocalForward", args...); err != nil {
+			t.Fatalf("LocalForward %q returned error: %s", args, err)
+		}
+	}
+
+	want := []Forward{
+		{BindPort: 8080, Host: "localhost", HostPort: 80},
+		{BindAddress: "::1", BindPort: 8081, Host: "::1", HostPort: 81},
+	}
+	if len(conf.LocalForwards) != len(want) {
+		t.Fatalf("got %d local forwards, want %d", len(conf.LocalForwards), len(want))
+	}
+	for i, f := range conf.LocalForwards {
+		if *f != want[i] {
+			t.Errorf("local forward %d = %+v, want %+v", i, *f, want[i])
+		}
+	}
+
+	if err := conf.SetOption("LocalForward", "8080"); err == nil {
+		t.Error("LocalForward without destination returned no error")
+	}
+}
+
+func TestRemoteForwardOption(t *testing.T) {
+	conf := NewConfig()
+	for _, args := range [][]string{
+		{"8080", "localhost:80"},
+		{"*:0", "localhost:22"},
+		{"[::1]:8081", "[2001:db8::1]:81"},
+	} {
+		if err := conf.SetOption("RemoteForward", args...); err != nil {
+			t.Fatalf("RemoteForward %q returned error: %s", args, err)
+		}
+	}
+
+	tests := []struct {
+		fwd    Forward
+		listen string
+	}{
+		{
+			fwd:    Forward{BindPort: 8080, Host: "localhost", HostPort: 80},
+			listen: "localhost:8080",
+		},
+		{
+			fwd:    Forward{BindAddress: "*", BindPort: 0, Host: "localhost", HostPort: 22},
+			listen: "0.0.0.0:0",
+		},
+		{
+			fwd:    Forward{BindAddress: "::1", BindPort: 8081, Host: "2001:db8::1", HostPort: 81},
+			listen: "[::1]:8081",
+		},
+	}
+	if len(conf.RemoteForwards) != len(tests) {
+		t.Fatalf("got %d remote forwards, want %d", len(conf.RemoteForwards), len(tests))
+	}
+	for i, tt := range tests {
+		f := conf.RemoteForwards[i]
+		if *f != tt.fwd {
+			t.Errorf("remote forward %d = %+v, want %+v", i, *f, tt.fwd)
+		}
+		if got := f.remoteListenAddr(); got != tt.listen {
+			t.Errorf("remoteListenAddr() of %+v = %q, want %q", *f, got, tt.listen)
+		}
+	}
+}
+
+func TestCancelForward(t *testing.T) {
+	tests := []struct {
+		kind    string
+		bind    string
+		spec    string
+		wantErr bool
+	}{
+		{kind: "local", bind: "", spec: "8080"},
+		{kind: "local", bind: "", spec: "localhost:8080"},
+		{kind: "local", bind: "", spec: "LOCALHOST:8080"},
+		{kind: "local", bind: "localhost", spec: "8080"},
+		{kind: "remote", bind: "*", spec: "0.0.0.0:8080"},
+		{kind: "remote", bind: "0.0.0.0", spec: "*:8080"},
+		{kind: "dynamic", bind: "*", spec: ":8080"},
+		{kind: "local", bind: "127.0.0.1", spec: "127.0.0.1:8080"},
+		{kind: "local", bind: "", spec: "8081", wantErr: true},
+		{kind: "local", bind: "", spec: "*:8080", wantErr: true},
+		{kind: "local", bind: "127.0.0.1", spec: "192.0.2.1:8080", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ms := &MinSSH{conf: NewConfig()}
+		ms.addForwardListener(&forwardListener{
+			kind:     tt.kind,
+			fwd:      &Forward{BindAddress: tt.bind, BindPort: 8080, Host: "localhost", HostPort: 80},
+			Listener: l,
+		})
+
+		f, err := ParseDynamicForward(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ms.cancelForward(tt.kind, f)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("canceling %s forwarding %q bound to %q returned no error", tt.kind, tt.spec, tt.bind)
+			}
+			l.Close()
+			continue
+		}
+		if err != nil {
+			t.Errorf("canceling %s forwarding %q bound to %q returned error: %s", tt.kind, tt.spec, tt.bind, err)
+			l.Close()
+			continue
+		}
+		if len(ms.fwdListeners) != 0 {
+			t.Errorf("canceling %s forwarding %q left the listener", tt.kind, tt.spec)
+		}
+		if _, err = l.Accept(); err == nil {
+			t.Errorf("canceling %s forwarding %q didn't close the listener", tt.kind, tt.spec)
+		}
+	}
+}
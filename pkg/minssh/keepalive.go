@@ -0,0 +1,84 @@
+package minssh
+
+import (
+	"fmt"
+	"time"
+)
+
+const keepaliveRequest string = "keepalive@openssh.com"
+
+// defaultServerAliveCountMax is the same as OpenSSH's
+const defaultServerAliveCountMax int = 3
+
+// startKeepalive sends keepalive requests every ServerAliveInterval. like
+// OpenSSH, if ServerAliveCountMax requests in a row are left unanswered, it
+// closes the connection so that running sessions finish
+func (ms *MinSSH) startKeepalive() {
+	if ms.conf.ServerAliveInterval <= 0 {
+		return
+	}
+	countMax := ms.conf.ServerAliveCountMax
+	if countMax <= 0 {
+		countMax = defaultServerAliveCountMax
+	}
+
+	ms.keepaliveStopC = make(chan struct{})
+	stopC := ms.keepaliveStopC
+
+	go func() {
+		t := time.NewTicker(ms.conf.ServerAliveInterval)
+		defer t.Stop()
+
+		// ssh.Client sends a request only after the previous one is replied
+		// so that later ones wait in their goroutines. there are at most
+		// countMax of them
+		replyC := make(chan error, countMax)
+		unanswered := 0
+		for {
+			select {
+			case <-stopC:
+				return
+			case err := <-replyC:
+				if err != nil {
+					// the connection has been closed
+					return
+				}
+				unanswered = 0
+			case <-t.C:
+				if unanswered >= countMax {
+					ms.setConnError(fmt.Errorf("Timeout, server %s not responding", ms.conf.Host))
+					ms.conn.Close()
+					return
+				}
+				if unanswered > 0 {
+					ms.conf.Logger.Printf("no reply to keepalive from %s (%d/%d)\n", ms.Hostport(), unanswered, countMax)
+				}
+				unanswered++
+				go func() {
+					_, _, err := ms.conn.SendRequest(keepaliveRequest, true, nil)
+					replyC <- err
+				}()
+			}
+		}
+	}()
+}
+
+func (ms *MinSSH) stopKeepalive() {
+	if ms.keepaliveStopC != nil {
+		close(ms.keepaliveStopC)
+		ms.keepaliveStopC = nil
+	}
+}
+
+// setConnError records why the connection is closed by minssh itself
+func (ms *MinSSH) setConnError(err error) {
+	ms.connErrMu.Lock()
+	defer ms.connErrMu.Unlock()
+	ms.connErr = err
+}
+
+func (ms *MinSSH) connError() error {
+	ms.connErrMu.Lock()
+	defer ms.connErrMu.Unlock()
+	return ms.connErr
+}
@@ -0,0 +1,93 @@
+package minssh
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const testAliveInterval = 20 * time.Millisecond
+
+func TestKeepalive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the server replies to keepalive requests
+	l, hostKey, _ := startTestServer(t, testServerOptions{})
+	defer l.Close()
+
+	conf := newTestClientConfig(t, dir, l, hostKey)
+	conf.ServerAliveInterval = testAliveInterval
+	conf.ServerAliveCountMax = 2
+
+	ms, err := Open(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ms.Close()
+
+	time.Sleep(10 * testAliveInterval)
+	if err = ms.connError(); err != nil {
+		t.Errorf("connection to the responding server was closed: %s", err)
+	}
+	if _, _, err = ms.conn.SendRequest("test@minssh", true, nil); err != nil {
+		t.Errorf("connection to the responding server doesn't work: %s", err)
+	}
+}
+
+func TestKeepaliveTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the server never replies to keepalive requests
+	reqs := make(chan *ssh.Request, 16)
+	l, hostKey, _ := startTestServer(t, testServerOptions{requests: reqs})
+	defer l.Close()
+
+	const countMax = 3
+	conf := newTestClientConfig(t, dir, l, hostKey)
+	conf.ServerAliveInterval = testAliveInterval
+	conf.ServerAliveCountMax = countMax
+
+	start := time.Now()
+	ms, err := Open(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ms.Close()
+
+	waitC := make(chan error, 1)
+	go func() {
+		waitC <- ms.conn.Wait()
+	}()
+	select {
+	case <-waitC:
+	case <-time.After(100 * testAliveInterval):
+		t.Fatal("connection to the hung server wasn't closed")
+	}
+
+	if err = ms.connError(); err == nil {
+		t.Error("connection error isn't set")
+	}
+	// it waits for replies to countMax requests sent every interval
+	if elapsed := time.Since(start); elapsed < countMax*testAliveInterval {
+		t.Errorf("connection was closed in %s, want after %s", elapsed, countMax*testAliveInterval)
+	}
+	if len(reqs) == 0 {
+		t.Error("server got no keepalive request")
+	}
+	for len(reqs) > 0 {
+		if req := <-reqs; req.Type != keepaliveRequest {
+			t.Errorf("server got %q request, want %q", req.Type, keepaliveRequest)
+		}
+	}
+}
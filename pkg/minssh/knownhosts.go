@@ -0,0 +1,292 @@
+package minssh
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// probeKey is a public key never matching any known_hosts entry. it is used
+// to look up keys recorded for a host
+type probeKey struct{}
+
+func (probeKey) Type() string                                 { return "minssh-probe" }
+func (probeKey) Marshal() []byte                              { return []byte("minssh-probe") }
+func (probeKey) Verify(data []byte, sig *ssh.Signature) error { return fmt.Errorf("probe key") }
+
+// knownHostKeys returns keys recorded in known_hosts files for conf's host
+// and port
+func knownHostKeys(conf *Config) ([]knownhosts.KnownKey, error) {
+	if len(conf.KnownHostsFiles) == 0 {
+		return nil, nil
+	}
+	hostKeyCallback, err := knownhosts.New(conf.KnownHostsFiles...)
+	if err != nil {
+		return nil, err
+	}
+
+	// the hostname is checked prior to the remote address
+	err = hostKeyCallback(conf.hostport(), &net.TCPAddr{IP: net.IPv4zero}, probeKey{})
+	if keyErr, ok := err.(*knownhosts.KeyError); ok {
+		return keyErr.Want, nil
+	}
+	return nil, err
+}
+
+// hostKeyAlgorithms returns host key algorithms offered to conf's server.
+// like OpenSSH, algorithms of the keys already known are put first so that
+// the server doesn't choose another type of key and fail in verification
+func (ms *MinSSH) hostKeyAlgorithms(conf *Config) []string {
+	if conf.HostKeyAlgorithms != nil {
+		return conf.HostKeyAlgorithms
+	}
+
+	keys, err := knownHostKeys(conf)
+	if err != nil {
+		ms.conf.Logger.Printf("failed to look up known host keys: %s\n", err)
+		return nil
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	defaults := algorithmSets()["key"].defaults
+	var preferred []string
+	for _, k := range keys {
+		for _, a := range keyTypeAlgorithms(k.Key.Type()) {
+			if containsString(defaults, a) {
+				preferred = appendIfMissing(preferred, a)
+			}
+		}
+	}
+	if len(preferred) == 0 {
+		return nil
+	}
+
+	ms.conf.Logger.Printf("prefer host key algorithms %v known for %s\n", preferred, conf.hostport())
+	return appendIfMissing(preferred, defaults...)
+}
+
+// keyTypeAlgorithms returns signature algorithms usable with a key type
+func keyTypeAlgorithms(keyType string) []string {
+	if keyType == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	return []string{keyType}
+}
+
+// keyTypeName returns a key type name used in messages like OpenSSH
+func keyTypeName(keyType string) string {
+	switch {
+	case keyType == ssh.KeyAlgoRSA:
+		return "RSA"
+	case keyType == ssh.KeyAlgoDSA:
+		return "DSA"
+	case keyType == ssh.KeyAlgoED25519:
+		return "ED25519"
+	case keyType == ssh.KeyAlgoSKED25519:
+		return "ED25519-SK"
+	case keyType == ssh.KeyAlgoSKECDSA256:
+		return "ECDSA-SK"
+	case strings.HasPrefix(keyType, "ecdsa-"):
+		return "ECDSA"
+	}
+	return keyType
+}
+
+// printHostKeyChanged warns that the host key differs from the known ones
+// like OpenSSH
+func printHostKeyChanged(hostname string, key ssh.PublicKey, known []knownhosts.KnownKey) {
+	w := os.Stderr
+	fmt.Fprint(w, "@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@\n"+
+		"@    WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED!     @\n"+
+		"@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@\n"+
+		"IT IS POSSIBLE THAT SOMEONE IS DOING SOMETHING NASTY!\n"+
+		"Someone could be eavesdropping on you right now (man-in-the-middle attack)!\n"+
+		"It is also possible that a host key has just been changed.\n")
+	fmt.Fprintf(w, "The fingerprint for the %s key sent by the remote host is\n%s.\n",
+		keyTypeName(key.Type()), ssh.FingerprintSHA256(key))
+	fmt.Fprint(w, "Please contact your system administrator.\n")
+	var files []string
+	for _, k := range known {
+		fmt.Fprintf(w, "Offending %s key in %s:%d\n", keyTypeName(k.Key.Type()), k.Filename, k.Line)
+		fmt.Fprintf(w, "  its fingerprint is %s\n", ssh.FingerprintSHA256(k.Key))
+		files = appendIfMissing(files, k.Filename)
+	}
+	// the file is given explicitly because it may be one of OpenSSH's
+	// files, which are edited by default only with -U
+	fmt.Fprint(w, "  remove with:\n")
+	for _, f := range files {
+		fmt.Fprintf(w, "  %s known-hosts -f \"%s\" -R \"%s\"\n",
+			filepath.Base(os.Args[0]), f, knownhosts.Normalize(hostname))
+	}
+	fmt.Fprintf(w, "Host key for %s has changed and you have requested strict checking.\n", knownhosts.Normalize(hostname))
+}
+
+// matchKnownHostsLine reports whether a known_hosts line is for host, which
+// must be normalized by knownhosts.Normalize. lines with markers like
+// "@cert-authority" never match
+func matchKnownHostsLine(line []byte, host string) bool {
+	marker, hosts, _, _, _, err := ssh.ParseKnownHosts(line)
+	if err != nil || marker != "" {
+		return false
+	}
+
+	var patterns []string
+	for _, h := range hosts {
+		if strings.HasPrefix(h, "|") {
+			if matchHashedHost(h, host) {
+				return true
+			}
+			continue
+		}
+		patterns = append(patterns, h)
+	}
+	return matchPatternList(patterns, host)
+}
+
+// matchHashedHost matches host to an entry hashed like "|1|salt|hash"
+func matchHashedHost(entry, host string) bool {
+	parts := strings.Split(entry, "|")
+	if len(parts) != 4 || parts[1] != "1" {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	hash, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return hmac.Equal(mac.Sum(nil), hash)
+}
+
+// rewriteKnownHosts passes each line of filename to edit and writes lines it
+// returns back. the file is replaced only when some line is changed and the
+// original file is kept as "filename.old" like ssh-keygen
+func rewriteKnownHosts(filename string, edit func(line string) (string, bool)) (changed bool, err error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return false, err
+	}
+
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := scanner.Text()
+		newLine, keep := edit(line)
+		if !keep || newLine != line {
+			changed = true
+		}
+		if keep {
+			out.WriteString(newLine + "\n")
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return false, err
+	}
+	if !changed {
+		return false, nil
+	}
+
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return false, err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".")
+	if err != nil {
+		return false, fmt.Errorf("failed to create temporary file: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(out.Bytes()); err == nil {
+		err = tmp.Chmod(fi.Mode().Perm())
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to write temporary file: %s", err)
+	}
+
+	old := filename + ".old"
+	os.Remove(old)
+	if err = os.Link(filename, old); err != nil {
+		if err = os.Rename(filename, old); err != nil {
+			return false, fmt.Errorf("failed to keep original file: %s", err)
+		}
+	}
+	if err = os.Rename(tmp.Name(), filename); err != nil {
+		return false, fmt.Errorf("failed to replace %s: %s", filename, err)
+	}
+	return true, nil
+}
+
+// RemoveKnownHost removes all keys of host from a known_hosts file and
+// returns line numbers of removed entries. host is given like "example.com"
+// or "[example.com]:2222"
+func RemoveKnownHost(filename, host string) ([]int, error) {
+	host = knownhosts.Normalize(host)
+
+	var removed []int
+	n := 0
+	_, err := rewriteKnownHosts(filename, func(line string) (string, bool) {
+		n++
+		if matchKnownHostsLine([]byte(line), host) {
+			removed = append(removed, n)
+			return line, false
+		}
+		return line, true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// HashKnownHostsFile replaces plain hostnames in a known_hosts file with
+// hashed ones like "ssh-keygen -H" and returns how many hostnames are
+// hashed. entries with a wildcard or a marker are kept as they are because
+// they can't be hashed
+func HashKnownHostsFile(filename string) (int, error) {
+	hashed := 0
+	_, err := rewriteKnownHosts(filename, func(line string) (string, bool) {
+		marker, hosts, _, _, _, err := ssh.ParseKnownHosts([]byte(line))
+		if err != nil || marker != "" {
+			return line, true
+		}
+		for _, h := range hosts {
+			if strings.HasPrefix(h, "|") || strings.ContainsAny(h, "*?!") {
+				return line, true
+			}
+		}
+
+		// a hashed entry can have only one hostname so that a line is split
+		// into lines for each of them
+		line = strings.TrimSpace(line)
+		rest := line[strings.IndexAny(line, " \t"):]
+		var lines []string
+		for _, h := range hosts {
+			lines = append(lines, knownhosts.HashHostname(knownhosts.Normalize(h))+rest)
+			hashed++
+		}
+		return strings.Join(lines, "\n"), true
+	})
+	if err != nil {
+		return 0, err
+	}
+	return hashed, nil
+}
@@ -0,0 +1,338 @@
+package minssh
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newTestPublicKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// writeKnownHosts writes lines to a known_hosts file in dir. "KEY" in a line
+// is replaced with a public key
+func writeKnownHosts(t *testing.T, dir string, lines []string) (filename, content string) {
+	key := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(newTestPublicKey(t))))
+	content = strings.Replace(strings.Join(lines, "\n")+"\n", "KEY", key, -1)
+	filename = filepath.Join(dir, "known_hosts")
+	if err := ioutil.WriteFile(filename, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return filename, content
+}
+
+func TestMatchKnownHostsLine(t *testing.T) {
+	key := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(newTestPublicKey(t))))
+	hashed := knownhosts.HashHostname("example.com")
+
+	tests := []struct {
+		line string
+		host string
+		want bool
+	}{
+		{"example.com " + key, "example.com", true},
+		{"EXAMPLE.COM " + key, "example.com", true},
+		{"example.com " + key, "[example.com]:2222", false},
+		{"[example.com]:2222 " + key, "[example.com]:2222", true},
+		{"[example.com]:2222 " + key, "example.com", false},
+		{"other.com,example.com " + key, "example.com", true},
+		{"*.example.com " + key, "www.example.com", true},
+		{"*.com,!example.com " + key, "example.com", false},
+		{hashed + " " + key, "example.com", true},
+		{hashed + " " + key, "example.org", false},
+		{"|1|bad|hash " + key, "example.com", false},
+		{"@cert-authority example.com " + key, "example.com", false},
+		{"@revoked example.com " + key, "example.com", false},
+		{"# example.com " + key, "example.com", false},
+		{"", "example.com", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchKnownHostsLine([]byte(tt.line), tt.host); got != tt.want {
+			t.Errorf("matchKnownHostsLine(%q, %q) = %v, want %v", tt.line, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestRemoveKnownHost(t *testing.T) {
+	lines := []string{
+		"example.com KEY",
+		"# comment for example.com",
+		"[example.com]:2222 KEY",
+		"other.com,example.com KEY",
+		"@cert-authority example.com KEY",
+		knownhosts.HashHostname("example.com") + " KEY",
+		"*.com,!example.com KEY",
+		"",
+		"other.com KEY",
+	}
+
+	tests := []struct {
+		host    string
+		removed []int
+	}{
+		{"example.com", []int{1, 4, 6}},
+		{"[example.com]:2222", []int{3}},
+		{"example.com:2222", []int{3}},
+		{"other.com", []int{4, 7, 9}},
+		{"example.org", nil},
+	}
+
+	for _, tt := range tests {
+		dir, err := ioutil.TempDir("", "minssh")
+		if err != nil {
+			t.Fatal(err)
+		}
+		filename, content := writeKnownHosts(t, dir, lines)
+
+		removed, err := RemoveKnownHost(filename, tt.host)
+		if err != nil {
+			t.Errorf("RemoveKnownHost(%q) returned error: %s", tt.host, err)
+			os.RemoveAll(dir)
+			continue
+		}
+		if !reflect.DeepEqual(removed, tt.removed) {
+			t.Errorf("RemoveKnownHost(%q) removed lines %v, want %v", tt.host, removed, tt.removed)
+		}
+
+		orig := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+		var want []string
+		for i, line := range orig {
+			if !containsInt(tt.removed, i+1) {
+				want = append(want, line)
+			}
+		}
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(b); got != strings.Join(want, "\n")+"\n" {
+			t.Errorf("RemoveKnownHost(%q) left\n%s\nwant\n%s", tt.host, got, strings.Join(want, "\n"))
+		}
+
+		b, err = ioutil.ReadFile(filename + ".old")
+		if len(tt.removed) == 0 {
+			if !os.IsNotExist(err) {
+				t.Errorf("RemoveKnownHost(%q) made backup without removing anything", tt.host)
+			}
+		} else if err != nil || string(b) != content {
+			t.Errorf("RemoveKnownHost(%q) didn't keep the original file: %v", tt.host, err)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRemoveKnownHostKeepsMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename, _ := writeKnownHosts(t, dir, []string{"example.com KEY", "other.com KEY"})
+	if err = os.Chmod(filename, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = RemoveKnownHost(filename, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := os.FileMode(0644); fi.Mode().Perm() != want && runtime.GOOS != "windows" {
+		t.Errorf("known_hosts has mode %s after removing a host, want %s", fi.Mode().Perm(), want)
+	}
+
+	if _, err = RemoveKnownHost(filepath.Join(dir, "missing"), "example.com"); err == nil {
+		t.Error("RemoveKnownHost returned no error for a missing file")
+	}
+}
+
+func containsInt(list []int, n int) bool {
+	for _, i := range list {
+		if i == n {
+			return true
+		}
+	}
+	return false
+}
+
+func TestHashKnownHostsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := newTestPublicKey(t)
+	keyText := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	hashed := knownhosts.HashHostname("hashed.example.com") + " " + keyText
+	kept := []string{
+		"# comment",
+		hashed,
+		"*.example.org " + keyText,
+		"@cert-authority ca.example.com " + keyText,
+		"",
+	}
+	content := strings.Join(append([]string{
+		"example.com,192.0.2.1 " + keyText,
+		"[example.com]:2222 " + keyText,
+	}, kept...), "\n") + "\n"
+	filename := filepath.Join(dir, "known_hosts")
+	if err = ioutil.WriteFile(filename, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := HashKnownHostsFile(filename)
+	if err != nil {
+		t.Fatalf("HashKnownHostsFile returned error: %s", err)
+	}
+	if n != 3 {
+		t.Errorf("HashKnownHostsFile hashed %d hostnames, want 3", n)
+	}
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if len(lines) != 3+len(kept) {
+		t.Fatalf("hashed file has %d lines, want %d:\n%s", len(lines), 3+len(kept), b)
+	}
+	for i, host := range []string{"example.com", "192.0.2.1", "[example.com]:2222"} {
+		if !strings.HasPrefix(lines[i], "|1|") || !matchKnownHostsLine([]byte(lines[i]), host) {
+			t.Errorf("line %d %q isn't a hashed entry of %s", i+1, lines[i], host)
+		}
+		if !strings.HasSuffix(lines[i], " "+keyText) {
+			t.Errorf("line %d %q lost its key", i+1, lines[i])
+		}
+	}
+	if !reflect.DeepEqual(lines[3:], kept) {
+		t.Errorf("lines which can't be hashed were changed to %q, want %q", lines[3:], kept)
+	}
+	if b, err = ioutil.ReadFile(filename + ".old"); err != nil || string(b) != content {
+		t.Errorf("HashKnownHostsFile didn't keep the original file: %v", err)
+	}
+
+	// the hashed file is still usable for host key verification
+	hostKeyCallback, err := knownhosts.New(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, address := range []string{"example.com:22", "192.0.2.1:22", "example.com:2222"} {
+		if err = hostKeyCallback(address, &net.TCPAddr{IP: net.IPv4zero}, key); err != nil {
+			t.Errorf("host key of %s isn't verified by hashed file: %s", address, err)
+		}
+	}
+
+	// the second run has nothing to hash and leaves the file as it is
+	os.Remove(filename + ".old")
+	if n, err = HashKnownHostsFile(filename); err != nil || n != 0 {
+		t.Errorf("HashKnownHostsFile of hashed file = %d, %v, want 0, nil", n, err)
+	}
+	if _, err = os.Stat(filename + ".old"); !os.IsNotExist(err) {
+		t.Error("HashKnownHostsFile made backup without hashing anything")
+	}
+}
+
+func TestHostKeyAlgorithms(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPub, err := ssh.NewPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaPub, err := ssh.NewPublicKey(&ecdsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := []string{
+		knownhosts.Line([]string{"example.com"}, newTestPublicKey(t)),
+		knownhosts.Line([]string{"example.com"}, rsaPub),
+		knownhosts.Line([]string{"other.com", "[example.com]:2222"}, ecdsaPub),
+	}
+	filename := filepath.Join(dir, "known_hosts")
+	if err = ioutil.WriteFile(filename, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	defaults := algorithmSets()["key"].defaults
+	// keyTypeAlgorithms lists the algorithms in the order of preference
+	known := func(keyTypes ...string) (r []string) {
+		for _, kt := range keyTypes {
+			for _, a := range keyTypeAlgorithms(kt) {
+				if containsString(defaults, a) {
+					r = append(r, a)
+				}
+			}
+		}
+		return r
+	}
+
+	tests := []struct {
+		host              string
+		port              int
+		hostKeyAlgorithms []string
+		preferred         []string
+		want              []string
+	}{
+		{host: "example.com", port: 22, preferred: known(ssh.KeyAlgoED25519, ssh.KeyAlgoRSA)},
+		{host: "other.com", port: 22, preferred: known(ssh.KeyAlgoECDSA256)},
+		{host: "example.com", port: 2222, preferred: known(ssh.KeyAlgoECDSA256)},
+		{host: "unknown.com", port: 22, want: nil},
+		{host: "example.com", port: 22, hostKeyAlgorithms: []string{ssh.KeyAlgoECDSA256}, want: []string{ssh.KeyAlgoECDSA256}},
+	}
+
+	for _, tt := range tests {
+		conf := NewConfig()
+		conf.Host = tt.host
+		conf.Port = tt.port
+		conf.KnownHostsFiles = []string{filename}
+		conf.HostKeyAlgorithms = tt.hostKeyAlgorithms
+		ms := &MinSSH{conf: conf}
+
+		want := tt.want
+		if tt.preferred != nil {
+			want = appendIfMissing(append([]string{}, tt.preferred...), defaults...)
+		}
+		got := ms.hostKeyAlgorithms(conf)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("host key algorithms for %s:%d = %q, want %q", tt.host, tt.port, got, want)
+		}
+	}
+}
@@ -17,21 +17,57 @@ import (
 	"syscall"
 
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 	"golang.org/x/crypto/ssh/knownhosts"
 	"golang.org/x/crypto/ssh/terminal"
 )
 
+// ExitStatusConnectionError is an exit status used when an error occurs in
+// minssh itself, e.g. connection or authentication failure, like OpenSSH
+const ExitStatusConnectionError int = 255
+
 const (
 	defaultTermName string = "xterm"
 	maxPromptTries  int    = 3
 )
 
 type MinSSH struct {
+	// ExitHandler is called when a session or the connection finishes
+	// instead of printing the exit message. err is nil on success,
+	// *ssh.ExitError if the remote command failed, *ExitError if the
+	// subsystem failed and others on errors including a local signal which
+	// made minssh quit
+	ExitHandler func(err error)
+
 	conf *Config
 
 	conn *ssh.Client
 	sess *ssh.Session
 
+	// connections to jump hosts in order from the nearest one
+	jumpConns []*ssh.Client
+
+	fileSigners map[string]ssh.Signer
+
+	agent            agent.ExtendedAgent
+	agentConn        net.Conn
+	agentFwdMu       sync.Mutex
+	isAgentForwarded bool
+
+	fwdMu        sync.Mutex
+	fwdListeners []*forwardListener
+	fwdConns     map[*forwardConn]struct{}
+
+	// control is set when it is a master sharing the connection
+	control *controlMaster
+	// isControlClient is true when it uses a connection of a master
+	isControlClient bool
+
+	keepaliveStopC chan struct{}
+	// connErr is the reason why minssh closed the connection
+	connErrMu sync.Mutex
+	connErr   error
+
 	rStdin  io.WriteCloser
 	rStdout io.Reader
 	rStderr io.Reader
@@ -39,6 +75,8 @@ type MinSSH struct {
 	sys *sysInfo
 
 	wg sync.WaitGroup
+
+	exitStatus int
 }
 
 func IsTerminal() (bool, error) {
@@ -106,9 +144,10 @@ func askAddingUnknownHostKey(address string, remote net.Addr, key ssh.PublicKey)
 		}
 	}()
 
-	fmt.Printf("The authenticity of host '%s (%s)' can't be established.\n", address, remote.String())
-	fmt.Printf("RSA key fingerprint is %s\n", ssh.FingerprintSHA256(key))
-	fmt.Printf("Are you sure you want to continue connecting (yes/no)? ")
+	// prompts are written to stderr so that stdout has only remote outputs
+	fmt.Fprintf(os.Stderr, "The authenticity of host '%s (%s)' can't be established.\n", address, remote.String())
+	fmt.Fprintf(os.Stderr, "%s key fingerprint is %s\n", keyTypeName(key.Type()), ssh.FingerprintSHA256(key))
+	fmt.Fprintf(os.Stderr, "Are you sure you want to continue connecting (yes/no)? ")
 
 	b := bufio.NewReader(os.Stdin)
 	for {
@@ -122,7 +161,7 @@ func askAddingUnknownHostKey(address string, remote net.Addr, key ssh.PublicKey)
 		} else if answer == "no" {
 			return false, nil
 		}
-		fmt.Print("Please type 'yes' or 'no': ")
+		fmt.Fprint(os.Stderr, "Please type 'yes' or 'no': ")
 	}
 	return false, nil
 }
@@ -143,8 +182,8 @@ func askDecodingEncryptedKey(keyPath string) (bool, error) {
 		}
 	}()
 
-	fmt.Printf("%q is encrypted\n", keyPath)
-	fmt.Printf("do you want to decrypt it (yes/no)? ")
+	fmt.Fprintf(os.Stderr, "%q is encrypted\n", keyPath)
+	fmt.Fprintf(os.Stderr, "do you want to decrypt it (yes/no)? ")
 
 	b := bufio.NewReader(os.Stdin)
 	for {
@@ -158,35 +197,93 @@ func askDecodingEncryptedKey(keyPath string) (bool, error) {
 		} else if answer == "no" {
 			return false, nil
 		}
-		fmt.Print("Please type 'yes' or 'no': ")
+		fmt.Fprint(os.Stderr, "Please type 'yes' or 'no': ")
 	}
 	return false, nil
 }
 
 func Open(conf *Config) (ms *MinSSH, err error) {
-	ms = &MinSSH{conf: conf, sys: &sysInfo{}}
+	ms = &MinSSH{
+		conf:        conf,
+		sys:         &sysInfo{},
+		fileSigners: make(map[string]ssh.Signer),
+	}
 
-	config := &ssh.ClientConfig{
-		User: ms.conf.User,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeysCallback(ms.getSigners),
-			ssh.RetryableAuthMethod(ssh.KeyboardInteractive(ms.keyboardInteractiveChallenge), maxPromptTries),
-			ssh.RetryableAuthMethod(ssh.PasswordCallback(ms.passwordCallback), maxPromptTries),
-		},
-		HostKeyCallback: ms.verifyAndAppendNew,
+	ms.connectAgent()
+
+	if conf.ControlPath != "" && conf.ControlMaster != ControlMasterYes {
+		if ms.conn, err = dialControl(conf); err == nil {
+			ms.conf.Logger.Printf("connected to %s via control master\n", ms.Hostport())
+			ms.isControlClient = true
+			ms.startForwards()
+			return ms, nil
+		}
+		ms.conf.Logger.Printf("failed to connect to control master: %s\n", err)
 	}
 
-	if ms.conn, err = ssh.Dial("tcp", ms.Hostport(), config); err != nil {
+	if err = ms.dial(); err != nil {
+		ms.Close()
 		return nil, fmt.Errorf("cannot connect to %s: %s", ms.Hostport(), err)
 	}
 
-	if ms.sess, err = ms.conn.NewSession(); err != nil {
-		return nil, fmt.Errorf("cannot create session: %s", err)
+	if conf.ControlPath != "" && (conf.ControlMaster == ControlMasterYes || conf.ControlMaster == ControlMasterAuto) {
+		if err = ms.startControlMaster(); err != nil {
+			ms.printInfo("Warning: %s\n", err)
+		}
 	}
 
+	ms.startKeepalive()
+	ms.startForwards()
+
 	return ms, nil
 }
 
+func (ms *MinSSH) clientConfig(conf *Config) *ssh.ClientConfig {
+	return &ssh.ClientConfig{
+		Config: ssh.Config{
+			Ciphers:      conf.Ciphers,
+			MACs:         conf.MACs,
+			KeyExchanges: conf.KexAlgorithms,
+		},
+		User: conf.User,
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+				return ms.getSigners(conf)
+			}),
+			ssh.RetryableAuthMethod(ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+				return ms.keyboardInteractiveChallenge(conf, user, instruction, questions, echos)
+			}), maxPromptTries),
+			ssh.RetryableAuthMethod(ssh.PasswordCallback(func() (string, error) {
+				return ms.passwordCallback(conf)
+			}), maxPromptTries),
+		},
+		HostKeyCallback:   ms.verifyAndAppendNew,
+		HostKeyAlgorithms: ms.hostKeyAlgorithms(conf),
+	}
+}
+
+// requestSender sends requests on a session. it is *ssh.Session or a session
+// channel opened directly
+type requestSender interface {
+	SendRequest(name string, wantReply bool, payload []byte) (bool, error)
+}
+
+func (ms *MinSSH) newSession() (err error) {
+	if ms.sess, err = ms.conn.NewSession(); err != nil {
+		return fmt.Errorf("cannot create session: %s", err)
+	}
+
+	ms.sendEnv(ms.sess)
+
+	if ms.conf.ForwardAgent {
+		if err = ms.requestAgentForwarding(ms.sess); err != nil {
+			ms.conf.Logger.Printf("failed to request agent forwarding: %s\n", err)
+		}
+	}
+
+	return nil
+}
+
 func (ms *MinSSH) verifyAndAppendNew(hostname string, remote net.Addr, key ssh.PublicKey) error {
 	if len(ms.conf.KnownHostsFiles) == 0 {
 		return fmt.Errorf("there is no knownhosts file")
@@ -203,9 +300,13 @@ func (ms *MinSSH) verifyAndAppendNew(hostname string, remote net.Addr, key ssh.P
 	}
 
 	keyErr, ok := err.(*knownhosts.KeyError)
-	if !ok || len(keyErr.Want) > 0 {
+	if !ok {
 		return err
 	}
+	if len(keyErr.Want) > 0 {
+		printHostKeyChanged(hostname, key, keyErr.Want)
+		return fmt.Errorf("host key verification failed")
+	}
 
 	if answer, err := askAddingUnknownHostKey(hostname, remote, key); err != nil || !answer {
 		msg := "host key verification failed"
@@ -221,30 +322,54 @@ func (ms *MinSSH) verifyAndAppendNew(hostname string, remote net.Addr, key ssh.P
 	}
 	defer f.Close()
 
-	var addrs []string
-	if remote.String() == hostname {
-		addrs = []string{hostname}
-	} else {
-		addrs = []string{hostname, remote.String()}
+	addrs := []string{hostname}
+	// remote address is unknown when it's connected via jump host
+	if addr, ok := remote.(*net.TCPAddr); ok && !addr.IP.IsUnspecified() && remote.String() != hostname {
+		addrs = append(addrs, remote.String())
 	}
 
-	entry := knownhosts.Line(addrs, key)
-	if _, err = f.WriteString(entry + "\n"); err != nil {
-		return fmt.Errorf("failed to add new host key: %s", err)
+	entries := []string{knownhosts.Line(addrs, key)}
+	if ms.conf.HashKnownHosts {
+		// a hashed entry can have only one hostname
+		entries = nil
+		for _, addr := range addrs {
+			entries = append(entries, knownhosts.Line([]string{knownhosts.HashHostname(knownhosts.Normalize(addr))}, key))
+		}
+	}
+	for _, entry := range entries {
+		if _, err = f.WriteString(entry + "\n"); err != nil {
+			return fmt.Errorf("failed to add new host key: %s", err)
+		}
 	}
 
 	return nil
 }
 
-func (ms *MinSSH) getSigners() (signers []ssh.Signer, err error) {
-	ttyin, ttyout, err := openTTY()
-	if err != nil {
-		return signers, fmt.Errorf("failed to open tty: %s", err)
-	}
-	defer closeTTY(ttyin, ttyout)
+func (ms *MinSSH) getSigners(conf *Config) (signers []ssh.Signer, err error) {
+	// tty is opened only when it is needed to decrypt a key so that it can
+	// run without tty, e.g. as a subsystem transport of other commands
+	var ttyin, ttyout *os.File
+	defer func() {
+		if ttyin != nil {
+			closeTTY(ttyin, ttyout)
+		}
+	}()
+
+	// keys in ssh-agent are tried before the ones in identity files
+	agentSigners := ms.getAgentSigners()
+	signers = append(signers, agentSigners...)
 
-	for _, identityFile := range ms.conf.IdentityFiles {
-		identityFile = os.ExpandEnv(identityFile)
+	for _, identityFile := range conf.IdentityFiles {
+		identityFile = conf.expandTokens(os.ExpandEnv(identityFile))
+		if isKeyInAgent(identityFile, agentSigners) {
+			ms.conf.Logger.Printf("skip private key %q already in ssh-agent\n", identityFile)
+			continue
+		}
+		// keys are cached not to ask passphrase again for jump hosts
+		if signer, ok := ms.fileSigners[identityFile]; ok {
+			signers = append(signers, signer)
+			continue
+		}
 		key, err := ioutil.ReadFile(identityFile)
 		if err != nil {
 			ms.conf.Logger.Printf("failed to read private key %q: %s\n", identityFile, err)
@@ -260,6 +385,12 @@ func (ms *MinSSH) getSigners() (signers []ssh.Signer, err error) {
 				}
 				continue
 			}
+			if ttyin == nil {
+				if ttyin, ttyout, err = openTTY(); err != nil {
+					ms.conf.Logger.Printf("failed to open tty: %s\n", err)
+					continue
+				}
+			}
 			password, err := readPassword(ttyin, ttyout, "password for decrypting key: ")
 			if err != nil {
 				ms.conf.Logger.Printf("failed to decrypt private key: %s\n", err)
@@ -278,13 +409,14 @@ func (ms *MinSSH) getSigners() (signers []ssh.Signer, err error) {
 			ms.conf.Logger.Printf("failed to parse private key: %s\n", err)
 			continue
 		}
+		ms.fileSigners[identityFile] = signer
 		signers = append(signers, signer)
 	}
 
 	return signers, nil
 }
 
-func (ms *MinSSH) keyboardInteractiveChallenge(user, instruction string, questions []string, echos []bool) (answers []string, err error) {
+func (ms *MinSSH) keyboardInteractiveChallenge(conf *Config, user, instruction string, questions []string, echos []bool) (answers []string, err error) {
 	ttyin, ttyout, err := openTTY()
 	if err != nil {
 		return answers, fmt.Errorf("failed to open tty: %s", err)
@@ -303,7 +435,7 @@ func (ms *MinSSH) keyboardInteractiveChallenge(user, instruction string, questio
 		if len(strs) > 0 {
 			fmt.Fprintln(ttyout, strings.Join(strs, " "))
 		} else {
-			fmt.Fprintf(ttyout, "Keyboard interactive challenge for %s@%s\n", ms.conf.User, ms.conf.Host)
+			fmt.Fprintf(ttyout, "Keyboard interactive challenge for %s@%s\n", conf.User, conf.Host)
 		}
 	}
 	for i, q := range questions {
@@ -316,14 +448,14 @@ func (ms *MinSSH) keyboardInteractiveChallenge(user, instruction string, questio
 	return answers, err
 }
 
-func (ms *MinSSH) passwordCallback() (secret string, err error) {
+func (ms *MinSSH) passwordCallback(conf *Config) (secret string, err error) {
 	ttyin, ttyout, err := openTTY()
 	if err != nil {
 		return secret, fmt.Errorf("failed to open tty: %s", err)
 	}
 	defer closeTTY(ttyin, ttyout)
 
-	fmt.Fprintf(ttyout, "Password authentication for %s@%s\n", ms.conf.User, ms.conf.Host)
+	fmt.Fprintf(ttyout, "Password authentication for %s@%s\n", conf.User, conf.Host)
 	return readPassword(ttyin, ttyout, "Password: ")
 }
 
@@ -332,16 +464,25 @@ func (ms *MinSSH) Close() {
 	if err != nil {
 		ms.conf.Logger.Println(err)
 	}
+	ms.stopKeepalive()
+	ms.closeForwards()
+	ms.closeControl()
 	if ms.sess != nil {
 		ms.sess.Close()
 	}
 	if ms.conn != nil {
 		ms.conn.Close()
 	}
+	for i := len(ms.jumpConns) - 1; i >= 0; i-- {
+		ms.jumpConns[i].Close()
+	}
+	if ms.agentConn != nil {
+		ms.agentConn.Close()
+	}
 }
 
 func (ms *MinSSH) Hostport() string {
-	return fmt.Sprintf("%s:%d", ms.conf.Host, ms.conf.Port)
+	return ms.conf.hostport()
 }
 
 func (ms *MinSSH) prepareRemoteTerminal() (err error) {
@@ -356,7 +497,7 @@ func (ms *MinSSH) prepareRemoteTerminal() (err error) {
 	}
 
 	if !ms.conf.NoTTY {
-		if err = ms.sess.RequestPty(termName, h, w, ssh.TerminalModes{}); err != nil {
+		if err = ms.sess.RequestPty(termName, h, w, ms.terminalModes()); err != nil {
 			return fmt.Errorf("request for pseudo terminal failed: %s", err)
 		}
 	}
@@ -388,6 +529,14 @@ func (ms *MinSSH) prepareLocalTerminal() (err error) {
 	return nil
 }
 
+// sshSignals maps signals watched by watchSignals to the ones sent to remote
+var sshSignals = map[os.Signal]ssh.Signal{
+	os.Interrupt:    ssh.SIGINT,
+	syscall.SIGHUP:  ssh.SIGHUP,
+	syscall.SIGTERM: ssh.SIGTERM,
+	syscall.SIGQUIT: ssh.SIGQUIT,
+}
+
 func (ms *MinSSH) watchSignals() chan os.Signal {
 	sigC := make(chan os.Signal, 1)
 	signal.Notify(sigC, os.Interrupt, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGQUIT)
@@ -457,6 +606,7 @@ func (ms *MinSSH) invokeInOutPipes() {
 
 	go func() {
 		buf := make([]byte, 128)
+		es := ms.newEscapeState()
 		for {
 			n, err := ms.readFromStdin(buf)
 			if err != nil {
@@ -466,8 +616,17 @@ func (ms *MinSSH) invokeInOutPipes() {
 				ms.rStdin.Close()
 				return
 			}
-			if n > 0 {
-				_, err := ms.rStdin.Write(buf[:n])
+			b := buf[:n]
+			if es != nil {
+				var quit bool
+				if b, quit = ms.filterEscape(es, b); quit {
+					ms.setConnError(fmt.Errorf("disconnected by escape sequence"))
+					ms.conn.Close()
+					return
+				}
+			}
+			if len(b) > 0 {
+				_, err := ms.rStdin.Write(b)
 				if err != nil {
 					ms.conf.Logger.Printf("failed to write bytes to remote stdin: %s\n", err)
 					return
@@ -477,24 +636,80 @@ func (ms *MinSSH) invokeInOutPipes() {
 	}()
 }
 
-func (ms *MinSSH) printExitMessage(err error) {
-	fmt.Printf("ssh connection to %s closed ", ms.conf.Host)
-	if err != nil {
-		switch e := err.(type) {
-		case *ssh.ExitMissingError:
-			fmt.Printf("but remote didn't send exit status: %s\n", e)
-		case *ssh.ExitError:
-			fmt.Printf("with error: %s\n", e)
-		default:
-			fmt.Printf("with unknown error: %s\n", err)
-		}
-	} else {
-		fmt.Println("successfully")
+// printInfo prints an informational message or a warning to stderr unless
+// Quiet is set. prompts and errors which make minssh quit are always shown
+func (ms *MinSSH) printInfo(format string, a ...interface{}) {
+	if !ms.conf.Quiet {
+		fmt.Fprintf(os.Stderr, format, a...)
 	}
 }
 
+func (ms *MinSSH) printExitMessage(err error) {
+	connErr := ms.connError()
+	if connErr != nil {
+		err = connErr
+	}
+	if ms.ExitHandler != nil {
+		ms.ExitHandler(err)
+		return
+	}
+
+	var msg string
+	switch e := err.(type) {
+	case nil:
+		msg = " successfully"
+	case *ssh.ExitMissingError:
+		msg = fmt.Sprintf(" but remote didn't send exit status: %s", e)
+	case *ssh.ExitError, *ExitError:
+		msg = fmt.Sprintf(" with error: %s", e)
+	default:
+		msg = fmt.Sprintf(" with unknown error: %s", e)
+	}
+	if connErr != nil {
+		msg = ": " + connErr.Error()
+	}
+	ms.printInfo("ssh connection to %s closed%s\n", ms.conf.Host, msg)
+}
+
+// exitBySignal finishes a session interrupted by a local signal. the reason
+// is set as a connection error so that it is reported by the exit message or
+// ExitHandler and the exit status is ExitStatusConnectionError
+func (ms *MinSSH) exitBySignal(reason error) {
+	ms.setConnError(reason)
+	ms.printExitMessage(nil)
+	ms.exitStatus = ms.exitStatusOf(nil)
+}
+
+// exitStatusOf converts the result of a session to an exit status like
+// OpenSSH. a remote process killed by a signal results in 128 + signal number
+func (ms *MinSSH) exitStatusOf(err error) int {
+	if ms.connError() != nil {
+		return ExitStatusConnectionError
+	}
+	switch e := err.(type) {
+	case nil:
+		return 0
+	case *ssh.ExitError:
+		return e.ExitStatus()
+	case *ExitError:
+		return e.ExitStatus()
+	}
+	return ExitStatusConnectionError
+}
+
+// ExitStatus returns the exit status of the remote command or shell after
+// Run finishes. it is ExitStatusConnectionError if the session has ended
+// without reporting its status
+func (ms *MinSSH) ExitStatus() int {
+	return ms.exitStatus
+}
+
 func (ms *MinSSH) Run() (err error) {
-	if ms.conf.Command != "" {
+	if ms.conf.NoCommand {
+		err = ms.RunNoCommand()
+	} else if ms.conf.IsSubsystem {
+		err = ms.RunSubsystem()
+	} else if ms.conf.Command != "" {
 		err = ms.RunCommand()
 	} else {
 		err = ms.RunInteractive()
@@ -503,6 +718,10 @@ func (ms *MinSSH) Run() (err error) {
 }
 
 func (ms *MinSSH) RunCommand() error {
+	if err := ms.newSession(); err != nil {
+		return err
+	}
+
 	ms.sess.Stdin = os.Stdin
 	ms.sess.Stdout = os.Stdout
 	ms.sess.Stderr = os.Stderr
@@ -512,47 +731,95 @@ func (ms *MinSSH) RunCommand() error {
 		signal.Stop(sigC)
 	}()
 
-	sessC := make(chan error)
+	// buffered so that the goroutine doesn't leak when minssh quits by a
+	// signal before the command ends
+	sessC := make(chan error, 1)
 	go func() {
 		sessC <- ms.sess.Run(ms.conf.Command)
 	}()
 
-	select {
-	case <-sigC:
-		fmt.Println("got signal")
-	case err := <-sessC:
-		ms.printExitMessage(err)
+	// the first signal is sent to the remote command and the second one
+	// makes minssh quit in case the remote ignores it
+	signaled := false
+	for {
+		select {
+		case sig := <-sigC:
+			if signaled {
+				ms.exitBySignal(fmt.Errorf("got signal %s again, quit", sig))
+				return nil
+			}
+			signaled = true
+			if err := ms.sess.Signal(sshSignals[sig]); err != nil {
+				ms.conf.Logger.Printf("failed to send signal %s to remote: %s\n", sshSignals[sig], err)
+			} else {
+				ms.conf.Logger.Printf("sent signal %s to remote\n", sshSignals[sig])
+			}
+		case err := <-sessC:
+			ms.printExitMessage(err)
+			ms.exitStatus = ms.exitStatusOf(err)
+			return nil
+		}
 	}
-
-	return nil
 }
 
-func (ms *MinSSH) RunSubsystem() error {
-	ms.sess.Stdin = os.Stdin
-	ms.sess.Stdout = os.Stdout
-	ms.sess.Stderr = os.Stderr
+// RunSubsystem connects local stdin and stdout to the remote subsystem. it
+// can't use ssh.Session because its Stdin, Stdout and Wait work only with
+// commands and shells
+func (ms *MinSSH) RunSubsystem() (err error) {
+	sess, err := ms.startSubsystemSession(ms.conf.Command)
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	ms.rStdin = sess.stdin()
+	ms.rStdout = sess
+	ms.rStderr = sess.Stderr()
 
 	sigC := ms.watchSignals()
 	defer func() {
 		signal.Stop(sigC)
 	}()
 
-	sessC := make(chan error)
 	go func() {
-		sessC <- ms.sess.RequestSubsystem(ms.conf.Command)
+		if _, err := io.Copy(ms.rStdin, os.Stdin); err != nil {
+			ms.conf.Logger.Printf("failed to copy local stdin to remote one: %s\n", err)
+		}
+		ms.rStdin.Close()
+	}()
+
+	// the subsystem finishes when the remote closes its stdout and stderr
+	// and the session is closed after its exit status
+	doneC := make(chan error, 1)
+	go func() {
+		stderrC := make(chan error, 1)
+		go func() {
+			stderrC <- ms.copyToStderr()
+		}()
+		if err := ms.copyToStdout(); err != nil {
+			ms.conf.Logger.Printf("failed to copy remote stdout to local one: %s\n", err)
+		}
+		if err := <-stderrC; err != nil {
+			ms.conf.Logger.Printf("failed to copy remote stderr to local one: %s\n", err)
+		}
+		doneC <- sess.Wait()
 	}()
 
 	select {
-	case <-sigC:
-		fmt.Println("got signal")
-	case err := <-sessC:
+	case sig := <-sigC:
+		ms.exitBySignal(fmt.Errorf("got signal %s", sig))
+	case err := <-doneC:
 		ms.printExitMessage(err)
+		ms.exitStatus = ms.exitStatusOf(err)
 	}
 
 	return nil
 }
 
 func (ms *MinSSH) RunInteractive() error {
+	if err := ms.newSession(); err != nil {
+		return err
+	}
 	if err := ms.prepareRemoteTerminal(); err != nil {
 		return err
 	}
@@ -572,16 +839,47 @@ func (ms *MinSSH) RunInteractive() error {
 	ms.invokeResizeTerminal(ctx)
 	ms.invokeInOutPipes()
 
-	sessC := make(chan error)
+	sessC := make(chan error, 1)
 	go func() {
 		sessC <- ms.sess.Wait()
 	}()
 
 	select {
-	case <-sigC:
-		fmt.Println("got signal")
+	case sig := <-sigC:
+		ms.exitBySignal(fmt.Errorf("got signal %s", sig))
 	case err := <-sessC:
 		ms.printExitMessage(err)
+		ms.exitStatus = ms.exitStatusOf(err)
+	}
+
+	return nil
+}
+
+// RunNoCommand doesn't open any session and just keeps the connection for
+// port forwardings until it is closed
+func (ms *MinSSH) RunNoCommand() error {
+	sigC := ms.watchSignals()
+	defer func() {
+		signal.Stop(sigC)
+	}()
+
+	connC := make(chan error, 1)
+	go func() {
+		connC <- ms.conn.Wait()
+	}()
+
+	select {
+	case sig := <-sigC:
+		ms.exitBySignal(fmt.Errorf("got signal %s", sig))
+	case err := <-connC:
+		if err == io.EOF {
+			err = nil
+		}
+		ms.printExitMessage(err)
+		ms.exitStatus = ms.exitStatusOf(err)
+	case <-ms.controlDone():
+		ms.conf.Logger.Printf("control master stopped\n")
+		ms.exitStatus = 0
 	}
 
 	return nil
@@ -0,0 +1,178 @@
+package minssh
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestExitBySignal(t *testing.T) {
+	var (
+		called bool
+		gotErr error
+	)
+	ms := &MinSSH{
+		conf: NewConfig(),
+		ExitHandler: func(err error) {
+			called = true
+			gotErr = err
+		},
+	}
+
+	ms.exitBySignal(fmt.Errorf("got signal interrupt"))
+
+	if !called {
+		t.Fatal("ExitHandler wasn't called")
+	}
+	if gotErr == nil || gotErr.Error() != "got signal interrupt" {
+		t.Errorf("ExitHandler got %v, want %q", gotErr, "got signal interrupt")
+	}
+	if ms.ExitStatus() != ExitStatusConnectionError {
+		t.Errorf("exit status is %d, want %d", ms.ExitStatus(), ExitStatusConnectionError)
+	}
+}
+
+func TestRunNoCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conns := make(chan *ssh.ServerConn, 1)
+	l, hostKey, users := startTestServer(t, testServerOptions{conns: conns})
+	defer l.Close()
+
+	conf := newTestClientConfig(t, dir, l, hostKey)
+	conf.NoCommand = true
+
+	ms, err := Open(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ms.Close()
+	<-users
+
+	// the session is created only when a command or shell runs
+	if ms.sess != nil {
+		t.Error("Open created a session")
+	}
+
+	var gotErr error
+	ms.ExitHandler = func(err error) {
+		gotErr = err
+	}
+	// -N keeps the connection until the server closes it
+	(<-conns).Close()
+	if err = ms.Run(); err != nil {
+		t.Fatalf("Run returned error: %s", err)
+	}
+
+	if ms.sess != nil {
+		t.Error("Run created a session with -N")
+	}
+	if gotErr != nil {
+		t.Errorf("ExitHandler got %v, want nil", gotErr)
+	}
+	if ms.ExitStatus() != 0 {
+		t.Errorf("exit status is %d, want 0", ms.ExitStatus())
+	}
+}
+
+func TestRunExitStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name        string
+		isSubsystem bool
+		status      uint32
+	}{
+		{name: "command", status: 0},
+		{name: "command", status: 3},
+		{name: "subsystem", isSubsystem: true, status: 0},
+		{name: "subsystem", isSubsystem: true, status: 3},
+	}
+
+	for _, tt := range tests {
+		l, hostKey, _ := startTestServer(t, testServerOptions{sessions: true, exitStatus: tt.status})
+
+		conf := newTestClientConfig(t, dir, l, hostKey)
+		conf.Command = "test"
+		conf.IsSubsystem = tt.isSubsystem
+		conf.NoTTY = true
+
+		ms, err := Open(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var gotErr error
+		ms.ExitHandler = func(err error) {
+			gotErr = err
+		}
+		if err = ms.Run(); err != nil {
+			t.Errorf("%s exiting with %d: Run returned error: %s", tt.name, tt.status, err)
+		}
+		ms.Close()
+		l.Close()
+
+		if ms.ExitStatus() != int(tt.status) {
+			t.Errorf("%s exiting with %d: exit status is %d", tt.name, tt.status, ms.ExitStatus())
+		}
+		var isExitErr bool
+		switch gotErr.(type) {
+		case *ssh.ExitError, *ExitError:
+			isExitErr = true
+		}
+		if (tt.status != 0) != isExitErr {
+			t.Errorf("%s exiting with %d: ExitHandler got %v", tt.name, tt.status, gotErr)
+		}
+	}
+}
+
+func TestSubsystemExit(t *testing.T) {
+	exitStatus := func(status uint32) *ssh.Request {
+		return &ssh.Request{Type: "exit-status", Payload: ssh.Marshal(struct{ Status uint32 }{status})}
+	}
+	exitSignal := func(sig string) *ssh.Request {
+		return &ssh.Request{Type: "exit-signal", Payload: ssh.Marshal(struct {
+			Signal     string
+			CoreDumped bool
+			Error      string
+			Lang       string
+		}{sig, false, "killed", ""})}
+	}
+
+	tests := []struct {
+		reqs   []*ssh.Request
+		status int
+	}{
+		{reqs: []*ssh.Request{exitStatus(0)}, status: 0},
+		{reqs: []*ssh.Request{{Type: "keepalive@openssh.com"}, exitStatus(2)}, status: 2},
+		{reqs: []*ssh.Request{exitSignal("TERM")}, status: 128 + 15},
+		{reqs: []*ssh.Request{exitSignal("UNKNOWN")}, status: 128},
+		{reqs: nil, status: ExitStatusConnectionError},
+	}
+
+	ms := &MinSSH{conf: NewConfig()}
+	for _, tt := range tests {
+		reqC := make(chan *ssh.Request, len(tt.reqs))
+		for _, req := range tt.reqs {
+			reqC <- req
+		}
+		close(reqC)
+
+		s := &subsystemSession{exitC: make(chan error, 1)}
+		s.handleRequests(reqC)
+		err := s.Wait()
+		if got := ms.exitStatusOf(err); got != tt.status {
+			t.Errorf("subsystem exited with %v, exit status is %d, want %d", err, got, tt.status)
+		}
+	}
+}
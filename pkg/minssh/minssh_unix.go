@@ -6,8 +6,11 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 
 	"golang.org/x/crypto/ssh/terminal"
@@ -96,6 +99,18 @@ func (ms *MinSSH) watchTerminalResize(ctx context.Context) <-chan struct{} {
 	return ch
 }
 
+// suspend stops minssh by SIGTSTP with the terminal restored and makes it raw
+// again when it is continued
+func (ms *MinSSH) suspend() error {
+	if err := ms.restoreLocalTerminalMode(); err != nil {
+		return fmt.Errorf("failed to restore terminal mode: %s", err)
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTSTP); err != nil {
+		return fmt.Errorf("failed to suspend: %s", err)
+	}
+	return ms.changeLocalTerminalMode()
+}
+
 func (ms *MinSSH) readFromStdin(b []byte) (n int, err error) {
 	return os.Stdin.Read(b)
 }
@@ -109,3 +124,49 @@ func (ms *MinSSH) copyToStderr() (err error) {
 	_, err = io.Copy(os.Stderr, ms.rStderr)
 	return
 }
+
+// listenControl listens the control socket. it is made in a private
+// directory and linked to path after its permission is fixed so that other
+// users can't connect to it in the meantime. like OpenSSH, linking fails if
+// path already exists. umask isn't changed because it is shared by all
+// goroutines. the returned listener doesn't remove path on Close
+func listenControl(path string) (net.Listener, error) {
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".minssh-control")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmpPath := filepath.Join(dir, "control")
+	l, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	if err = os.Chmod(tmpPath, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if err = os.Link(tmpPath, path); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// checkControlOwner makes sure that path is a socket owned by the current
+// user before connecting to it
+func checkControlOwner(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%q is not a socket", path)
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("%q is owned by another user (uid %d)", path, st.Uid)
+	}
+	return nil
+}
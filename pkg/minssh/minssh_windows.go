@@ -6,6 +6,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"strings"
 	"syscall"
@@ -375,6 +376,10 @@ func (ms *MinSSH) watchTerminalResize(ctx context.Context) <-chan struct{} {
 	return ch
 }
 
+func (ms *MinSSH) suspend() error {
+	return fmt.Errorf("suspend is not supported on Windows")
+}
+
 func (ms *MinSSH) readFromStdin(b []byte) (n int, err error) {
 	var stdin io.Reader
 	if ms.sys.emuStdin {
@@ -406,3 +411,14 @@ func (ms *MinSSH) copyToStderr() (err error) {
 	_, err = io.Copy(stderr, ms.rStderr)
 	return
 }
+
+// listenControl listens the control socket. Windows doesn't apply file
+// permissions to unix sockets
+func listenControl(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
+
+// checkControlOwner does nothing because a file owner isn't a uid on Windows
+func checkControlOwner(path string) error {
+	return nil
+}
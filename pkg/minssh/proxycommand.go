@@ -0,0 +1,93 @@
+package minssh
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// proxyCommandConn is a net.Conn over standard input and output of a proxy
+// command
+type proxyCommandConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("/bin/sh", "-c", command)
+}
+
+func (ms *MinSSH) startProxyCommand() (*proxyCommandConn, error) {
+	command := ms.conf.expandTokens(ms.conf.ProxyCommand)
+	ms.conf.Logger.Printf("starting proxy command %q\n", command)
+
+	c := &proxyCommandConn{cmd: shellCommand(command)}
+	c.cmd.Stderr = os.Stderr
+
+	var err error
+	if c.stdin, err = c.cmd.StdinPipe(); err != nil {
+		return nil, fmt.Errorf("failed to get proxy command stdin pipe: %s", err)
+	}
+	if c.stdout, err = c.cmd.StdoutPipe(); err != nil {
+		return nil, fmt.Errorf("failed to get proxy command stdout pipe: %s", err)
+	}
+	if err = c.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start proxy command %q: %s", command, err)
+	}
+
+	return c, nil
+}
+
+func (c *proxyCommandConn) Read(b []byte) (int, error) {
+	return c.stdout.Read(b)
+}
+
+func (c *proxyCommandConn) Write(b []byte) (int, error) {
+	return c.stdin.Write(b)
+}
+
+// Close kills the proxy command. it may be called again by a timeout while
+// the handshake is closing it
+func (c *proxyCommandConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.stdin.Close()
+		if c.cmd.Process != nil {
+			c.cmd.Process.Kill()
+		}
+		c.closeErr = c.cmd.Wait()
+	})
+	return c.closeErr
+}
+
+// the remote address is unknown. knownhosts package requires *net.TCPAddr
+func (c *proxyCommandConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4zero}
+}
+
+func (c *proxyCommandConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4zero}
+}
+
+func (c *proxyCommandConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *proxyCommandConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *proxyCommandConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
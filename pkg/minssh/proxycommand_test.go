@@ -0,0 +1,299 @@
+package minssh
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// TestProxyCommandHelper isn't a real test. it is run by proxy commands in
+// other tests and relays its stdin and stdout to the address given by
+// arguments like "cat" with nc. the arguments are recorded to the file given
+// by MINSSH_TEST_PROXY_ARGS to check token expansion
+func TestProxyCommandHelper(t *testing.T) {
+	argsFile := os.Getenv("MINSSH_TEST_PROXY_ARGS")
+	if argsFile == "" {
+		return
+	}
+
+	var args []string
+	for i, arg := range os.Args {
+		if arg == "--" {
+			args = os.Args[i+1:]
+			break
+		}
+	}
+	if err := ioutil.WriteFile(argsFile, []byte(strings.Join(args, " ")), 0600); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "missing host and port")
+		os.Exit(1)
+	}
+
+	c, err := net.Dial("tcp", net.JoinHostPort(args[0], args[1]))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	go func() {
+		io.Copy(c, os.Stdin)
+		c.(*net.TCPConn).CloseWrite()
+	}()
+	io.Copy(os.Stdout, c)
+	os.Exit(0)
+}
+
+// testServerOptions changes how the test server handles connections
+type testServerOptions struct {
+	// conns receives accepted connections so that a test can close them or
+	// open channels like agent ones to the client
+	conns chan<- *ssh.ServerConn
+	// sessions makes the server accept sessions and reply true to all their
+	// requests. other channels are always rejected
+	sessions bool
+	// exitStatus is sent by a session when a command, a shell or a subsystem
+	// is requested and the session is closed soon
+	exitStatus uint32
+	// directTCPIP makes the server accept "direct-tcpip" channels and relay
+	// them to their destinations like a jump host
+	directTCPIP bool
+	// requests receives global requests from clients instead of replying
+	// to them so that a test can make the server look hung
+	requests chan<- *ssh.Request
+}
+
+// startTestServer starts an in-process ssh server accepting any user without
+// authentication. users of accepted connections are sent to the returned
+// channel
+func startTestServer(t *testing.T, opts testServerOptions) (l net.Listener, hostKey ssh.PublicKey, users <-chan string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	l, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userC := make(chan string, 1)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				conn, chans, reqs, err := ssh.NewServerConn(c, config)
+				if err != nil {
+					return
+				}
+				// only the first user is kept for tests not reading them
+				select {
+				case userC <- conn.User():
+				default:
+				}
+				if opts.conns != nil {
+					opts.conns <- conn
+				}
+				if opts.requests != nil {
+					go func() {
+						for req := range reqs {
+							opts.requests <- req
+						}
+					}()
+				} else {
+					go ssh.DiscardRequests(reqs)
+				}
+				for nc := range chans {
+					if opts.directTCPIP && nc.ChannelType() == "direct-tcpip" {
+						go relayTestDirectTCPIP(nc)
+						continue
+					}
+					if !opts.sessions || nc.ChannelType() != "session" {
+						nc.Reject(ssh.Prohibited, "no such channels in test server")
+						continue
+					}
+					ch, creqs, err := nc.Accept()
+					if err != nil {
+						continue
+					}
+					go func() {
+						defer ch.Close()
+						for req := range creqs {
+							req.Reply(true, nil)
+							switch req.Type {
+							case "exec", "shell", "subsystem":
+								ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{opts.exitStatus}))
+								return
+							}
+						}
+					}()
+				}
+			}()
+		}
+	}()
+
+	return l, signer.PublicKey(), userC
+}
+
+func relayTestDirectTCPIP(nc ssh.NewChannel) {
+	var msg struct {
+		Host     string
+		Port     uint32
+		OrigHost string
+		OrigPort uint32
+	}
+	if err := ssh.Unmarshal(nc.ExtraData(), &msg); err != nil {
+		nc.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	c, err := net.Dial("tcp", net.JoinHostPort(msg.Host, fmt.Sprint(msg.Port)))
+	if err != nil {
+		nc.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	defer c.Close()
+	ch, reqs, err := nc.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+	go ssh.DiscardRequests(reqs)
+	relay(ch, c)
+}
+
+// writeTestKnownHosts writes a known_hosts file in dir having the host key
+// of the test server listening on addr
+func writeTestKnownHosts(t *testing.T, dir string, addr net.Addr, hostKey ssh.PublicKey) string {
+	filename := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr.String())}, hostKey)
+	if err := ioutil.WriteFile(filename, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// newTestClientConfig makes a Config to connect to the test server listening
+// on l without ssh-agent
+func newTestClientConfig(t *testing.T, dir string, l net.Listener, hostKey ssh.PublicKey) *Config {
+	addr := l.Addr().(*net.TCPAddr)
+	conf := NewConfig()
+	conf.Host = addr.IP.String()
+	conf.Port = addr.Port
+	conf.User = "alice"
+	conf.NoAgent = true
+	conf.KnownHostsFiles = []string{writeTestKnownHosts(t, dir, addr, hostKey)}
+	return conf
+}
+
+func TestProxyCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("proxy command in this test is written for /bin/sh")
+	}
+
+	dir, err := ioutil.TempDir("", "minssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	l, hostKey, users := startTestServer(t, testServerOptions{})
+	defer l.Close()
+	addr := l.Addr().(*net.TCPAddr)
+	knownHostsFile := writeTestKnownHosts(t, dir, addr, hostKey)
+
+	argsFile := filepath.Join(dir, "args")
+	line := fmt.Sprintf("ProxyCommand MINSSH_TEST_PROXY_ARGS='%s' '%s' -test.run=TestProxyCommandHelper -- %%h %%p %%r",
+		argsFile, os.Args[0])
+	key, args, err := SplitConfigLine(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := NewConfig()
+	conf.Host = addr.IP.String()
+	conf.Port = addr.Port
+	conf.User = "alice"
+	conf.NoAgent = true
+	conf.KnownHostsFiles = []string{knownHostsFile}
+	if err = conf.SetOption(key, args...); err != nil {
+		t.Fatal(err)
+	}
+
+	ms := &MinSSH{conf: conf, fileSigners: make(map[string]ssh.Signer)}
+	if err = ms.dial(); err != nil {
+		t.Fatalf("failed to connect via proxy command: %s", err)
+	}
+	ms.conn.Close()
+
+	if user := <-users; user != "alice" {
+		t.Errorf("server got user %q, want %q", user, "alice")
+	}
+
+	b, err := ioutil.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("%s %d alice", addr.IP, addr.Port)
+	if string(b) != want {
+		t.Errorf("proxy command got arguments %q, want %q", b, want)
+	}
+}
+
+func TestProxyCommandConfigLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{
+			line: `ProxyCommand bash -c "nc %h %p"`,
+			want: `bash -c "nc %h %p"`,
+		},
+		{
+			line: `ProxyCommand=ssh -W '%h:%p'  jump # not a comment`,
+			want: `ssh -W '%h:%p'  jump # not a comment`,
+		},
+		{
+			line: `ProxyCommand none`,
+			want: ``,
+		},
+	}
+
+	for _, tt := range tests {
+		key, args, err := SplitConfigLine(tt.line)
+		if err != nil {
+			t.Errorf("SplitConfigLine(%q) returned error: %s", tt.line, err)
+			continue
+		}
+		conf := NewConfig()
+		if err = conf.SetOption(key, args...); err != nil {
+			t.Errorf("SetOption(%q, %q) returned error: %s", key, args, err)
+			continue
+		}
+		if conf.ProxyCommand != tt.want {
+			t.Errorf("ProxyCommand from %q = %q, want %q", tt.line, conf.ProxyCommand, tt.want)
+		}
+	}
+}
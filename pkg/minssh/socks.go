@@ -0,0 +1,289 @@
+package minssh
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+const (
+	socks4Version byte = 4
+	socks5Version byte = 5
+
+	socksCmdConnect byte = 1
+
+	socks4Granted  byte = 0x5a
+	socks4Rejected byte = 0x5b
+
+	socks5NoAuth       byte = 0
+	socks5NoAcceptable byte = 0xff
+
+	socks5AddrIPv4   byte = 1
+	socks5AddrDomain byte = 3
+	socks5AddrIPv6   byte = 4
+
+	socks5Succeeded           byte = 0
+	socks5GeneralFailure      byte = 1
+	socks5CmdNotSupported     byte = 7
+	socks5AddrTypeUnsupported byte = 8
+)
+
+// maxSOCKS4StringLen limits length of USERID and host name in SOCKS4(a)
+// request
+const maxSOCKS4StringLen int = 255
+
+func ParseDynamicForward(spec string) (*Forward, error) {
+	fields, err := splitForwardSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Forward{}
+	switch len(fields) {
+	case 1:
+	case 2:
+		f.BindAddress = fields[0]
+		if f.BindAddress == "" {
+			f.BindAddress = "*"
+		}
+		fields = fields[1:]
+	default:
+		return nil, fmt.Errorf("bad dynamic forwarding specification %q", spec)
+	}
+
+	if f.BindPort, err = parseForwardPort(fields[0]); err != nil {
+		return nil, fmt.Errorf("bad dynamic forwarding specification %q: %s", spec, err)
+	}
+
+	return f, nil
+}
+
+// AddDynamicForward listens a local port as SOCKS4, SOCKS4a and SOCKS5 proxy
+// and connects to destinations through the ssh connection
+func (ms *MinSSH) AddDynamicForward(f *Forward) error {
+	l, err := net.Listen("tcp", f.ListenAddr())
+	if err != nil {
+		return fmt.Errorf("could not request dynamic forwarding %s: %s", f, err)
+	}
+	ms.conf.Logger.Printf("dynamic forwarding listens on %s\n", l.Addr())
+
+	ms.addForwardListener(&forwardListener{kind: "dynamic", fwd: f, Listener: l})
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				ms.conf.Logger.Printf("dynamic forwarding on %s stopped: %s\n", l.Addr(), err)
+				return
+			}
+			go ms.forwardSOCKSConn(c)
+		}
+	}()
+
+	return nil
+}
+
+func (ms *MinSSH) forwardSOCKSConn(c net.Conn) {
+	defer c.Close()
+
+	req, err := readSOCKSRequest(c)
+	if err != nil {
+		ms.conf.Logger.Printf("dynamic forwarding: bad request from %s: %s\n", c.RemoteAddr(), err)
+		return
+	}
+
+	ms.conf.Logger.Printf("dynamic forwarding: connection from %s to %s\n", c.RemoteAddr(), req.addr)
+	rc, err := ms.conn.Dial("tcp", req.addr)
+	if err != nil {
+		ms.conf.Logger.Printf("dynamic forwarding: failed to connect to %s: %s\n", req.addr, err)
+		req.reply(c, false)
+		return
+	}
+	defer rc.Close()
+
+	if err = req.reply(c, true); err != nil {
+		ms.conf.Logger.Printf("dynamic forwarding: failed to reply to %s: %s\n", c.RemoteAddr(), err)
+		return
+	}
+	defer ms.trackForwardConn("dynamic", c.RemoteAddr().String(), req.addr)()
+
+	relay(c, rc)
+	ms.conf.Logger.Printf("dynamic forwarding: connection from %s to %s closed\n", c.RemoteAddr(), req.addr)
+}
+
+type socksRequest struct {
+	version byte
+	addr    string
+}
+
+// readSOCKSRequest reads a SOCKS4, SOCKS4a or SOCKS5 CONNECT request. only
+// "no authentication" method is supported in SOCKS5
+func readSOCKSRequest(rw io.ReadWriter) (*socksRequest, error) {
+	var ver [1]byte
+	if _, err := io.ReadFull(rw, ver[:]); err != nil {
+		return nil, err
+	}
+
+	switch ver[0] {
+	case socks4Version:
+		return readSOCKS4Request(rw)
+	case socks5Version:
+		return readSOCKS5Request(rw)
+	}
+	return nil, fmt.Errorf("unsupported SOCKS version %d", ver[0])
+}
+
+func readSOCKS4Request(rw io.ReadWriter) (*socksRequest, error) {
+	// CMD(1) DSTPORT(2) DSTIP(4)
+	var buf [7]byte
+	if _, err := io.ReadFull(rw, buf[:]); err != nil {
+		return nil, err
+	}
+	req := &socksRequest{version: socks4Version}
+
+	// USERID is not used
+	if _, err := readNullTerminated(rw); err != nil {
+		return nil, err
+	}
+
+	if buf[0] != socksCmdConnect {
+		req.reply(rw, false)
+		return nil, fmt.Errorf("unsupported SOCKS4 command %d", buf[0])
+	}
+
+	port := binary.BigEndian.Uint16(buf[1:3])
+	ip := net.IP(buf[3:7])
+	host := ip.String()
+	// SOCKS4a: 0.0.0.x (x != 0) means a host name follows USERID
+	if ip[0] == 0 && ip[1] == 0 && ip[2] == 0 && ip[3] != 0 {
+		name, err := readNullTerminated(rw)
+		if err != nil {
+			return nil, err
+		}
+		host = name
+	}
+	req.addr = net.JoinHostPort(host, strconv.Itoa(int(port)))
+
+	return req, nil
+}
+
+func readSOCKS5Request(rw io.ReadWriter) (*socksRequest, error) {
+	var n [1]byte
+	if _, err := io.ReadFull(rw, n[:]); err != nil {
+		return nil, err
+	}
+	methods := make([]byte, n[0])
+	if _, err := io.ReadFull(rw, methods); err != nil {
+		return nil, err
+	}
+
+	method := socks5NoAcceptable
+	for _, m := range methods {
+		if m == socks5NoAuth {
+			method = socks5NoAuth
+			break
+		}
+	}
+	if _, err := rw.Write([]byte{socks5Version, method}); err != nil {
+		return nil, err
+	}
+	if method == socks5NoAcceptable {
+		return nil, fmt.Errorf("no acceptable SOCKS5 authentication method")
+	}
+
+	// VER(1) CMD(1) RSV(1) ATYP(1)
+	var head [4]byte
+	if _, err := io.ReadFull(rw, head[:]); err != nil {
+		return nil, err
+	}
+	if head[0] != socks5Version {
+		return nil, fmt.Errorf("unexpected SOCKS5 request version %d", head[0])
+	}
+
+	var host string
+	switch head[3] {
+	case socks5AddrIPv4:
+		ip := make([]byte, net.IPv4len)
+		if _, err := io.ReadFull(rw, ip); err != nil {
+			return nil, err
+		}
+		host = net.IP(ip).String()
+	case socks5AddrIPv6:
+		ip := make([]byte, net.IPv6len)
+		if _, err := io.ReadFull(rw, ip); err != nil {
+			return nil, err
+		}
+		host = net.IP(ip).String()
+	case socks5AddrDomain:
+		if _, err := io.ReadFull(rw, n[:]); err != nil {
+			return nil, err
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(rw, name); err != nil {
+			return nil, err
+		}
+		host = string(name)
+	default:
+		writeSOCKS5Reply(rw, socks5AddrTypeUnsupported)
+		return nil, fmt.Errorf("unsupported SOCKS5 address type %d", head[3])
+	}
+
+	var port [2]byte
+	if _, err := io.ReadFull(rw, port[:]); err != nil {
+		return nil, err
+	}
+
+	if head[1] != socksCmdConnect {
+		writeSOCKS5Reply(rw, socks5CmdNotSupported)
+		return nil, fmt.Errorf("unsupported SOCKS5 command %d", head[1])
+	}
+
+	return &socksRequest{
+		version: socks5Version,
+		addr:    net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))),
+	}, nil
+}
+
+func (req *socksRequest) reply(w io.Writer, ok bool) error {
+	if req.version == socks4Version {
+		// VN(1) CD(1) DSTPORT(2) DSTIP(4)
+		b := []byte{0, socks4Rejected, 0, 0, 0, 0, 0, 0}
+		if ok {
+			b[1] = socks4Granted
+		}
+		_, err := w.Write(b)
+		return err
+	}
+
+	if ok {
+		return writeSOCKS5Reply(w, socks5Succeeded)
+	}
+	return writeSOCKS5Reply(w, socks5GeneralFailure)
+}
+
+func writeSOCKS5Reply(w io.Writer, rep byte) error {
+	// VER(1) REP(1) RSV(1) ATYP(1) BND.ADDR(4) BND.PORT(2)
+	_, err := w.Write([]byte{socks5Version, rep, 0, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+func readNullTerminated(r io.Reader) (string, error) {
+	var (
+		buf []byte
+		b   [1]byte
+	)
+	for {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return "", err
+		}
+		if b[0] == 0 {
+			return string(buf), nil
+		}
+		if len(buf) >= maxSOCKS4StringLen {
+			return "", fmt.Errorf("too long string in SOCKS4 request")
+		}
+		buf = append(buf, b[0])
+	}
+}
@@ -0,0 +1,219 @@
+package minssh
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+// socksTestConn reads a request from in and records replies to out
+type socksTestConn struct {
+	in  io.Reader
+	out bytes.Buffer
+}
+
+func (c *socksTestConn) Read(b []byte) (int, error) {
+	return c.in.Read(b)
+}
+
+func (c *socksTestConn) Write(b []byte) (int, error) {
+	return c.out.Write(b)
+}
+
+func TestReadSOCKSRequest(t *testing.T) {
+	long := strings.Repeat("a", maxSOCKS4StringLen+1)
+
+	tests := []struct {
+		name    string
+		in      []byte
+		addr    string
+		version byte
+		// written is what is replied while reading the request
+		written []byte
+		wantErr bool
+	}{
+		{
+			name:    "SOCKS4",
+			in:      []byte{4, 1, 0x1f, 0x90, 192, 168, 0, 1, 'u', 's', 'e', 'r', 0},
+			addr:    "192.168.0.1:8080",
+			version: socks4Version,
+		},
+		{
+			name:    "SOCKS4 empty USERID",
+			in:      []byte{4, 1, 0, 80, 127, 0, 0, 1, 0},
+			addr:    "127.0.0.1:80",
+			version: socks4Version,
+		},
+		{
+			name:    "SOCKS4a",
+			in:      append([]byte{4, 1, 0, 80, 0, 0, 0, 1, 0}, "example.com\x00"...),
+			addr:    "example.com:80",
+			version: socks4Version,
+		},
+		{
+			name:    "SOCKS4 BIND",
+			in:      []byte{4, 2, 0, 80, 127, 0, 0, 1, 0},
+			written: []byte{0, socks4Rejected, 0, 0, 0, 0, 0, 0},
+			wantErr: true,
+		},
+		{
+			name:    "SOCKS4 too long USERID",
+			in:      append([]byte{4, 1, 0, 80, 127, 0, 0, 1}, long+"\x00"...),
+			wantErr: true,
+		},
+		{
+			name:    "SOCKS4a unterminated host name",
+			in:      append([]byte{4, 1, 0, 80, 0, 0, 0, 1, 0}, "example.com"...),
+			wantErr: true,
+		},
+		{
+			name:    "SOCKS4 truncated",
+			in:      []byte{4, 1, 0, 80},
+			wantErr: true,
+		},
+		{
+			name:    "SOCKS5 IPv4",
+			in:      []byte{5, 1, 0, 5, 1, 0, 1, 10, 0, 0, 1, 0x1f, 0x90},
+			addr:    "10.0.0.1:8080",
+			version: socks5Version,
+			written: []byte{5, socks5NoAuth},
+		},
+		{
+			name: "SOCKS5 IPv6",
+			in: []byte{5, 1, 0, 5, 1, 0, 4,
+				0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 22},
+			addr:    "[2001:db8::1]:22",
+			version: socks5Version,
+			written: []byte{5, socks5NoAuth},
+		},
+		{
+			name:    "SOCKS5 domain",
+			in:      append(append([]byte{5, 2, 2, 0, 5, 1, 0, 3, 11}, "example.com"...), 1, 187),
+			addr:    "example.com:443",
+			version: socks5Version,
+			written: []byte{5, socks5NoAuth},
+		},
+		{
+			name:    "SOCKS5 no acceptable method",
+			in:      []byte{5, 1, 2},
+			written: []byte{5, socks5NoAcceptable},
+			wantErr: true,
+		},
+		{
+			name: "SOCKS5 BIND",
+			in:   []byte{5, 1, 0, 5, 2, 0, 1, 10, 0, 0, 1, 0, 80},
+			written: []byte{5, socks5NoAuth,
+				5, socks5CmdNotSupported, 0, socks5AddrIPv4, 0, 0, 0, 0, 0, 0},
+			wantErr: true,
+		},
+		{
+			name: "SOCKS5 unknown address type",
+			in:   []byte{5, 1, 0, 5, 1, 0, 9},
+			written: []byte{5, socks5NoAuth,
+				5, socks5AddrTypeUnsupported, 0, socks5AddrIPv4, 0, 0, 0, 0, 0, 0},
+			wantErr: true,
+		},
+		{
+			name:    "SOCKS5 bad request version",
+			in:      []byte{5, 1, 0, 4, 1, 0, 1, 10, 0, 0, 1, 0, 80},
+			written: []byte{5, socks5NoAuth},
+			wantErr: true,
+		},
+		{
+			name:    "SOCKS5 truncated",
+			in:      []byte{5, 1, 0, 5, 1, 0, 3, 11, 'e', 'x'},
+			written: []byte{5, socks5NoAuth},
+			wantErr: true,
+		},
+		{
+			name:    "unknown version",
+			in:      []byte{3, 1},
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			in:      nil,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		c := &socksTestConn{in: bytes.NewReader(tt.in)}
+		req, err := readSOCKSRequest(c)
+		if !bytes.Equal(c.out.Bytes(), tt.written) {
+			t.Errorf("%s: replied %v, want %v", tt.name, c.out.Bytes(), tt.written)
+		}
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: returned no error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: returned error: %s", tt.name, err)
+			continue
+		}
+		if req.addr != tt.addr || req.version != tt.version {
+			t.Errorf("%s: got %q version %d, want %q version %d", tt.name, req.addr, req.version, tt.addr, tt.version)
+		}
+	}
+}
+
+func TestSOCKSReply(t *testing.T) {
+	tests := []struct {
+		version byte
+		ok      bool
+		want    []byte
+	}{
+		{socks4Version, true, []byte{0, socks4Granted, 0, 0, 0, 0, 0, 0}},
+		{socks4Version, false, []byte{0, socks4Rejected, 0, 0, 0, 0, 0, 0}},
+		{socks5Version, true, []byte{5, socks5Succeeded, 0, socks5AddrIPv4, 0, 0, 0, 0, 0, 0}},
+		{socks5Version, false, []byte{5, socks5GeneralFailure, 0, socks5AddrIPv4, 0, 0, 0, 0, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		req := &socksRequest{version: tt.version}
+		if err := req.reply(&buf, tt.ok); err != nil {
+			t.Errorf("reply(%v) of SOCKS%d returned error: %s", tt.ok, tt.version, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), tt.want) {
+			t.Errorf("reply(%v) of SOCKS%d = %v, want %v", tt.ok, tt.version, buf.Bytes(), tt.want)
+		}
+	}
+}
+
+func TestParseDynamicForward(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Forward
+		wantErr bool
+	}{
+		{spec: "1080", want: Forward{BindPort: 1080}},
+		{spec: "localhost:1080", want: Forward{BindAddress: "localhost", BindPort: 1080}},
+		{spec: ":1080", want: Forward{BindAddress: "*", BindPort: 1080}},
+		{spec: "[::1]:1080", want: Forward{BindAddress: "::1", BindPort: 1080}},
+		{spec: "socks", wantErr: true},
+		{spec: "a:b:1080", wantErr: true},
+		{spec: "[::1:1080", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseDynamicForward(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseDynamicForward(%q) returned no error", tt.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseDynamicForward(%q) returned error: %s", tt.spec, err)
+			continue
+		}
+		if *got != tt.want {
+			t.Errorf("ParseDynamicForward(%q) = %+v, want %+v", tt.spec, *got, tt.want)
+		}
+	}
+}
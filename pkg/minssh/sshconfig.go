@@ -0,0 +1,361 @@
+package minssh
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+const maxIncludeDepth int = 16
+
+// SSHConfig is a parsed OpenSSH style ssh_config file
+type SSHConfig struct {
+	blocks []*sshConfigBlock
+}
+
+type sshConfigOption struct {
+	key  string
+	args []string
+	file string
+	line int
+}
+
+type matchCriterion struct {
+	name   string
+	negate bool
+	arg    string
+}
+
+type sshConfigBlock struct {
+	// parent is the block which includes this block by "Include" keyword.
+	// it must match too to apply this block's options
+	parent *sshConfigBlock
+
+	isMatch  bool
+	hosts    []string
+	criteria []matchCriterion
+
+	options []sshConfigOption
+}
+
+func ReadSSHConfigFile(path string) (*SSHConfig, error) {
+	s := &SSHConfig{}
+	if err := s.readFile(path, filepath.Dir(path), nil, 0); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SSHConfig) readFile(path, baseDir string, parent *sshConfigBlock, depth int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return s.parse(f, path, baseDir, parent, depth)
+}
+
+func (s *SSHConfig) parse(r io.Reader, path, baseDir string, parent *sshConfigBlock, depth int) error {
+	cur := &sshConfigBlock{parent: parent}
+	s.blocks = append(s.blocks, cur)
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		key, args, err := SplitConfigLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s line %d: %s", path, lineNum, err)
+		}
+		if key == "" {
+			continue
+		}
+
+		switch key {
+		case "host":
+			if len(args) == 0 {
+				return fmt.Errorf("%s line %d: missing host pattern", path, lineNum)
+			}
+			cur = &sshConfigBlock{parent: parent, hosts: args}
+			s.blocks = append(s.blocks, cur)
+		case "match":
+			criteria, err := parseMatchCriteria(args)
+			if err != nil {
+				return fmt.Errorf("%s line %d: %s", path, lineNum, err)
+			}
+			cur = &sshConfigBlock{parent: parent, isMatch: true, criteria: criteria}
+			s.blocks = append(s.blocks, cur)
+		case "include":
+			if depth >= maxIncludeDepth {
+				return fmt.Errorf("%s line %d: too deeply nested includes", path, lineNum)
+			}
+			for _, arg := range args {
+				pattern := expandHomeDir(arg)
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(baseDir, pattern)
+				}
+				matches, err := filepath.Glob(pattern)
+				if err != nil {
+					return fmt.Errorf("%s line %d: bad include pattern %q: %s", path, lineNum, arg, err)
+				}
+				for _, m := range matches {
+					if err := s.readFile(m, baseDir, cur, depth+1); err != nil {
+						return fmt.Errorf("%s line %d: failed to include %q: %s", path, lineNum, m, err)
+					}
+				}
+			}
+			// options following "Include" still belong to the current block
+			// but they must be evaluated after the included ones
+			cur = &sshConfigBlock{
+				parent:   cur.parent,
+				isMatch:  cur.isMatch,
+				hosts:    cur.hosts,
+				criteria: cur.criteria,
+			}
+			s.blocks = append(s.blocks, cur)
+		default:
+			if len(args) == 0 {
+				return fmt.Errorf("%s line %d: missing argument for %q", path, lineNum, key)
+			}
+			cur.options = append(cur.options, sshConfigOption{
+				key:  key,
+				args: args,
+				file: path,
+				line: lineNum,
+			})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %s", path, err)
+	}
+
+	return nil
+}
+
+// SplitConfigLine splits a line to a lower cased keyword and its arguments.
+// both of "Keyword value" and "Keyword=value" forms are accepted
+func SplitConfigLine(line string) (key string, args []string, err error) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return "", nil, nil
+	}
+
+	i := strings.IndexFunc(line, func(r rune) bool {
+		return r == '=' || unicode.IsSpace(r)
+	})
+	if i == -1 {
+		return strings.ToLower(line), nil, nil
+	}
+	key = strings.ToLower(line[:i])
+	rest := strings.TrimLeftFunc(line[i:], unicode.IsSpace)
+	if strings.HasPrefix(rest, "=") {
+		rest = rest[1:]
+	}
+
+	// command is passed to shell as is
+	if key == "proxycommand" {
+		return key, []string{rest}, nil
+	}
+
+	args, err = SplitArgs(rest)
+	return key, args, err
+}
+
+// SplitArgs splits s by spaces like arguments in ssh_config. arguments can be
+// quoted by double quotes to contain spaces and "#" at the beginning of an
+// argument starts a comment
+func SplitArgs(s string) (args []string, err error) {
+	var (
+		buf     []rune
+		inQuote bool
+		inArg   bool
+	)
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			inArg = true
+		case unicode.IsSpace(r) && !inQuote:
+			if inArg {
+				args = append(args, string(buf))
+				buf = buf[:0]
+				inArg = false
+			}
+		case r == '#' && !inQuote && !inArg:
+			return args, nil
+		default:
+			buf = append(buf, r)
+			inArg = true
+		}
+	}
+	if inQuote {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inArg {
+		args = append(args, string(buf))
+	}
+	return args, nil
+}
+
+func parseMatchCriteria(args []string) (criteria []matchCriterion, err error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("missing match criteria")
+	}
+
+	for i := 0; i < len(args); i++ {
+		c := matchCriterion{name: strings.ToLower(args[i])}
+		if strings.HasPrefix(c.name, "!") {
+			c.negate = true
+			c.name = c.name[1:]
+		}
+		switch c.name {
+		case "all", "canonical", "final":
+		case "exec", "host", "originalhost", "user", "localuser":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("missing argument for match criterion %q", c.name)
+			}
+			i++
+			c.arg = args[i]
+		default:
+			return nil, fmt.Errorf("unsupported match criterion %q", c.name)
+		}
+		criteria = append(criteria, c)
+	}
+
+	return criteria, nil
+}
+
+// Apply sets options in matched blocks to conf. host is the name given by a
+// user, it may be an alias and is replaced by "HostName" option value.
+// As OpenSSH does, the first obtained value for each option is used so
+// options already set to conf are not changed
+func (s *SSHConfig) Apply(conf *Config, host string) error {
+	for _, b := range s.blocks {
+		ok, err := b.match(conf, host)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		for _, o := range b.options {
+			if _, ok := optionSetters[o.key]; !ok {
+				conf.Logger.Printf("%s line %d: ignore unsupported option %q\n", o.file, o.line, o.key)
+				continue
+			}
+			if err := conf.SetOption(o.key, o.args...); err != nil {
+				return fmt.Errorf("%s line %d: %s", o.file, o.line, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (b *sshConfigBlock) match(conf *Config, host string) (bool, error) {
+	if b.parent != nil {
+		if ok, err := b.parent.match(conf, host); err != nil || !ok {
+			return false, err
+		}
+	}
+
+	if b.isMatch {
+		for _, c := range b.criteria {
+			ok, err := c.match(conf, host)
+			if err != nil {
+				return false, err
+			}
+			if ok == c.negate {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+
+	if len(b.hosts) == 0 {
+		return true, nil
+	}
+	return matchPatternList(b.hosts, host), nil
+}
+
+func (c *matchCriterion) match(conf *Config, host string) (bool, error) {
+	switch c.name {
+	case "all", "canonical", "final":
+		return true, nil
+	case "host":
+		return matchPatternList(strings.Split(c.arg, ","), conf.Host), nil
+	case "originalhost":
+		return matchPatternList(strings.Split(c.arg, ","), host), nil
+	case "user":
+		return matchPatternList(strings.Split(c.arg, ","), conf.User), nil
+	case "localuser":
+		return matchPatternList(strings.Split(c.arg, ","), getDefaultUser()), nil
+	case "exec":
+		command := conf.expandTokens(c.arg)
+		if err := shellCommand(command).Run(); err != nil {
+			if _, ok := err.(*exec.ExitError); ok {
+				return false, nil
+			}
+			return false, fmt.Errorf("failed to run match exec command %q: %s", command, err)
+		}
+		return true, nil
+	}
+	return false, fmt.Errorf("unsupported match criterion %q", c.name)
+}
+
+// matchPatternList reports whether s matches to the list of patterns. a
+// pattern starting with "!" negates the match and takes precedence
+func matchPatternList(patterns []string, s string) (matched bool) {
+	s = strings.ToLower(s)
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if strings.HasPrefix(p, "!") {
+			if matchPattern(p[1:], s) {
+				return false
+			}
+			continue
+		}
+		if matchPattern(p, s) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// matchPattern matches s to a pattern which may contain "*" and "?"
+// wildcards
+func matchPattern(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchPattern(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+	return len(s) == 0
+}
@@ -0,0 +1,364 @@
+package minssh
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitConfigLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		key     string
+		args    []string
+		wantErr bool
+	}{
+		{line: "", key: ""},
+		{line: "   ", key: ""},
+		{line: "# comment", key: ""},
+		{line: "  # indented comment", key: ""},
+		{line: "Host example.com", key: "host", args: []string{"example.com"}},
+		{line: "HostName=example.com", key: "hostname", args: []string{"example.com"}},
+		{line: "HostName = example.com", key: "hostname", args: []string{"example.com"}},
+		{line: "HostName =example.com", key: "hostname", args: []string{"example.com"}},
+		{line: "\tPort\t2222", key: "port", args: []string{"2222"}},
+		{line: "Host a b  c", key: "host", args: []string{"a", "b", "c"}},
+		{line: "IdentityFile \"~/My Keys/id_rsa\"", key: "identityfile", args: []string{"~/My Keys/id_rsa"}},
+		{line: "IdentityFile=\"a b\"", key: "identityfile", args: []string{"a b"}},
+		{line: "SendEnv \"\"", key: "sendenv", args: []string{""}},
+		{line: "SendEnv LANG # trailing comment", key: "sendenv", args: []string{"LANG"}},
+		{line: "SendEnv LANG#NOT_COMMENT", key: "sendenv", args: []string{"LANG#NOT_COMMENT"}},
+		{line: "User \"foo", wantErr: true},
+		{line: "Compression", key: "compression"},
+		{
+			line: "ProxyCommand ssh -W %h:%p \"jump host\" # not a comment",
+			key:  "proxycommand",
+			args: []string{"ssh -W %h:%p \"jump host\" # not a comment"},
+		},
+	}
+
+	for _, tt := range tests {
+		key, args, err := SplitConfigLine(tt.line)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("SplitConfigLine(%q) returned no error", tt.line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("SplitConfigLine(%q) returned error: %s", tt.line, err)
+			continue
+		}
+		if key != tt.key || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("SplitConfigLine(%q) = %q, %q, want %q, %q", tt.line, key, args, tt.key, tt.args)
+		}
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		s       string
+		args    []string
+		wantErr bool
+	}{
+		{s: "", args: nil},
+		{s: "ls", args: []string{"ls"}},
+		{s: "  put  a\tb ", args: []string{"put", "a", "b"}},
+		{s: `get "remote file" "local file"`, args: []string{"get", "remote file", "local file"}},
+		{s: `rm a" "b`, args: []string{"rm", "a b"}},
+		{s: `mkdir ""`, args: []string{"mkdir", ""}},
+		{s: "rm a#b # comment", args: []string{"rm", "a#b"}},
+		{s: "# comment", args: nil},
+		{s: `cd "dir`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		args, err := SplitArgs(tt.s)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("SplitArgs(%q) returned no error", tt.s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("SplitArgs(%q) returned error: %s", tt.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("SplitArgs(%q) = %q, want %q", tt.s, args, tt.args)
+		}
+	}
+}
+
+func TestMatchPatternList(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		s        string
+		want     bool
+	}{
+		{[]string{"*"}, "example.com", true},
+		{[]string{"example.com"}, "EXAMPLE.com", true},
+		{[]string{"*.example.com"}, "example.com", false},
+		{[]string{"*.example.com"}, "www.example.com", true},
+		{[]string{"host?"}, "host1", true},
+		{[]string{"host?"}, "host12", false},
+		{[]string{"a*b*c"}, "axxbyyc", true},
+		{[]string{"a*b*c"}, "axxbyy", false},
+		{[]string{"foo", "bar"}, "bar", true},
+		{[]string{"*", "!bar"}, "bar", false},
+		{[]string{"!bar", "*"}, "bar", false},
+		{[]string{"!bar"}, "foo", false},
+		{[]string{"*.example.com", "!www.example.com"}, "ftp.example.com", true},
+	}
+
+	for _, tt := range tests {
+		if got := matchPatternList(tt.patterns, tt.s); got != tt.want {
+			t.Errorf("matchPatternList(%q, %q) = %v, want %v", tt.patterns, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestParseMatchCriteria(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    []matchCriterion
+		wantErr bool
+	}{
+		{args: []string{"all"}, want: []matchCriterion{{name: "all"}}},
+		{
+			args: []string{"Host", "*.example.com", "!User", "root"},
+			want: []matchCriterion{
+				{name: "host", arg: "*.example.com"},
+				{name: "user", negate: true, arg: "root"},
+			},
+		},
+		{args: nil, wantErr: true},
+		{args: []string{"host"}, wantErr: true},
+		{args: []string{"address", "10.0.0.1"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseMatchCriteria(tt.args)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseMatchCriteria(%q) returned no error", tt.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseMatchCriteria(%q) returned error: %s", tt.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseMatchCriteria(%q) = %+v, want %+v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestSSHConfigApply(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		host   string
+		user   string
+		port   int
+		idents []string
+	}{
+		{
+			name: "first value wins",
+			config: `
+Host example.com
+  User alice
+  Port 2222
+Host *
+  User bob
+  Port 22
+`,
+			host: "example.com",
+			user: "alice",
+			port: 2222,
+		},
+		{
+			name: "global options before host blocks",
+			config: `
+User carol
+Host example.com
+  User alice
+`,
+			host: "example.com",
+			user: "carol",
+			port: 22,
+		},
+		{
+			name: "negated host pattern",
+			config: `
+Host *.example.com !www.example.com
+  Port 2222
+`,
+			host: "www.example.com",
+			user: "default",
+			port: 22,
+		},
+		{
+			name: "alias resolved by hostname",
+			config: `
+Host ex
+  HostName example.com
+Match host example.com
+  User alice
+Match originalhost example.com
+  Port 2222
+`,
+			host: "ex",
+			user: "alice",
+			port: 22,
+		},
+		{
+			name: "match negation",
+			config: `
+Match !user root
+  Port 2222
+`,
+			host: "example.com",
+			user: "default",
+			port: 2222,
+		},
+		{
+			name: "identity files accumulate",
+			config: `
+Host example.com
+  IdentityFile ~/.ssh/id_a
+Host *
+  IdentityFile ~/.ssh/id_b
+`,
+			host:   "example.com",
+			user:   "default",
+			port:   22,
+			idents: []string{"~/.ssh/id_a", "~/.ssh/id_b"},
+		},
+	}
+
+	for _, tt := range tests {
+		s := &SSHConfig{}
+		if err := s.parse(strings.NewReader(tt.config), "config", ".", nil, 0); err != nil {
+			t.Errorf("%s: failed to parse: %s", tt.name, err)
+			continue
+		}
+		conf := NewConfig()
+		conf.User = "default"
+		conf.Host = tt.host
+		if err := s.Apply(conf, tt.host); err != nil {
+			t.Errorf("%s: failed to apply: %s", tt.name, err)
+			continue
+		}
+		if conf.User != tt.user || conf.Port != tt.port || !reflect.DeepEqual(conf.IdentityFiles, tt.idents) {
+			t.Errorf("%s: got user %q, port %d, identities %q, want %q, %d, %q",
+				tt.name, conf.User, conf.Port, conf.IdentityFiles, tt.user, tt.port, tt.idents)
+		}
+	}
+}
+
+func TestSSHConfigParseError(t *testing.T) {
+	tests := []string{
+		"Host",
+		"Match",
+		"Match host",
+		"Port",
+		"User \"alice",
+	}
+
+	for _, config := range tests {
+		s := &SSHConfig{}
+		if err := s.parse(strings.NewReader(config), "config", ".", nil, 0); err == nil {
+			t.Errorf("parsing %q returned no error", config)
+		}
+	}
+}
+
+func TestSSHConfigInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"config": `
+Host example.com
+  Include conf.d/*
+  User alice
+Host *
+  Include other
+`,
+		"conf.d/a": `
+Port 2222
+Host *
+  User bob
+`,
+		"conf.d/b": `
+Port 3333
+`,
+		"other": `
+Port 4444
+User carol
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := ReadSSHConfigFile(filepath.Join(dir, "config"))
+	if err != nil {
+		t.Fatalf("failed to read config: %s", err)
+	}
+
+	tests := []struct {
+		host string
+		user string
+		port int
+	}{
+		// included files are read in place of "Include" in lexical order
+		// and the blocks in them are valid only when the including block
+		// matches
+		{host: "example.com", user: "bob", port: 2222},
+		{host: "example.org", user: "carol", port: 4444},
+	}
+
+	for _, tt := range tests {
+		conf := NewConfig()
+		conf.Host = tt.host
+		if err := s.Apply(conf, tt.host); err != nil {
+			t.Errorf("failed to apply config to %q: %s", tt.host, err)
+			continue
+		}
+		if conf.User != tt.user || conf.Port != tt.port {
+			t.Errorf("config for %q: got user %q, port %d, want %q, %d",
+				tt.host, conf.User, conf.Port, tt.user, tt.port)
+		}
+	}
+}
+
+func TestSSHConfigIncludeLoop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(path, []byte("Include config\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadSSHConfigFile(path); err == nil {
+		t.Error("recursive include returned no error")
+	}
+}
@@ -0,0 +1,199 @@
+package minssh
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Subsystem is a subsystem like "sftp" running on its own session. Stdin
+// and Stdout are connected to the remote subsystem so that a protocol can
+// be put on top of them. the subsystem has finished when Stdout reaches EOF
+type Subsystem struct {
+	Stdin  io.WriteCloser
+	Stdout io.Reader
+
+	sess *ssh.Session
+}
+
+func (ms *MinSSH) OpenSubsystem(name string) (*Subsystem, error) {
+	sess, err := ms.conn.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("cannot create session: %s", err)
+	}
+
+	ms.sendEnv(sess)
+
+	s := &Subsystem{sess: sess}
+	if s.Stdin, err = sess.StdinPipe(); err != nil {
+		sess.Close()
+		return nil, fmt.Errorf("failed to get remote stdin pipe: %s", err)
+	}
+	if s.Stdout, err = sess.StdoutPipe(); err != nil {
+		sess.Close()
+		return nil, fmt.Errorf("failed to get remote stdout pipe: %s", err)
+	}
+	stderr, err := sess.StderrPipe()
+	if err != nil {
+		sess.Close()
+		return nil, fmt.Errorf("failed to get remote stderr pipe: %s", err)
+	}
+
+	if err = sess.RequestSubsystem(name); err != nil {
+		sess.Close()
+		return nil, fmt.Errorf("failed to request subsystem %q: %s", name, err)
+	}
+	ms.conf.Logger.Printf("started subsystem %q\n", name)
+
+	// remote stderr must be consumed not to block the session
+	go io.Copy(ioutil.Discard, stderr)
+
+	return s, nil
+}
+
+func (s *Subsystem) Close() error {
+	return s.sess.Close()
+}
+
+// ExitError is returned when a subsystem run by RunSubsystem fails. it is
+// the same as ssh.ExitError which can be made only by ssh.Session
+type ExitError struct {
+	Status int
+	Signal string
+	Msg    string
+}
+
+func (e *ExitError) ExitStatus() int {
+	return e.Status
+}
+
+func (e *ExitError) Error() string {
+	s := fmt.Sprintf("Process exited with status %d", e.Status)
+	if e.Signal != "" {
+		s += fmt.Sprintf(" from signal %s", e.Signal)
+	}
+	if e.Msg != "" {
+		s += fmt.Sprintf(". Reason was: %s", e.Msg)
+	}
+	return s
+}
+
+// signal numbers to make exit statuses of subsystems killed by signals. they
+// are the same as the ones used by ssh.Session
+var signalNumbers = map[ssh.Signal]int{
+	ssh.SIGABRT: 6,
+	ssh.SIGALRM: 14,
+	ssh.SIGFPE:  8,
+	ssh.SIGHUP:  1,
+	ssh.SIGILL:  4,
+	ssh.SIGINT:  2,
+	ssh.SIGKILL: 9,
+	ssh.SIGPIPE: 13,
+	ssh.SIGQUIT: 3,
+	ssh.SIGSEGV: 11,
+	ssh.SIGTERM: 15,
+}
+
+// subsystemSession is a session channel running a subsystem. ssh.Session
+// isn't used because its Wait works only for a command or a shell started
+// by it so that it can't tell how a subsystem has exited
+type subsystemSession struct {
+	ssh.Channel
+	exitC chan error
+}
+
+func (ms *MinSSH) startSubsystemSession(name string) (*subsystemSession, error) {
+	ch, reqs, err := ms.conn.OpenChannel("session", nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create session: %s", err)
+	}
+	s := &subsystemSession{Channel: ch, exitC: make(chan error, 1)}
+	go s.handleRequests(reqs)
+
+	ms.sendEnv(ch)
+
+	if ms.conf.ForwardAgent {
+		if err = ms.requestAgentForwarding(ch); err != nil {
+			ms.conf.Logger.Printf("failed to request agent forwarding: %s\n", err)
+		}
+	}
+
+	ok, err := ch.SendRequest("subsystem", true, ssh.Marshal(struct{ Name string }{name}))
+	if err == nil && !ok {
+		err = fmt.Errorf("subsystem request failed")
+	}
+	if err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to request subsystem %q: %s", name, err)
+	}
+	return s, nil
+}
+
+// handleRequests reads requests from the server until the channel is closed
+// and sends how the subsystem has exited like ssh.Session's Wait
+func (s *subsystemSession) handleRequests(reqs <-chan *ssh.Request) {
+	var exitErr *ExitError
+	for req := range reqs {
+		switch req.Type {
+		case "exit-status":
+			var msg struct {
+				Status uint32
+			}
+			if err := ssh.Unmarshal(req.Payload, &msg); err == nil {
+				exitErr = &ExitError{Status: int(msg.Status)}
+			}
+		case "exit-signal":
+			var msg struct {
+				Signal     string
+				CoreDumped bool
+				Error      string
+				Lang       string
+			}
+			if err := ssh.Unmarshal(req.Payload, &msg); err == nil {
+				exitErr = &ExitError{
+					Status: 128 + signalNumbers[ssh.Signal(msg.Signal)],
+					Signal: msg.Signal,
+					Msg:    msg.Error,
+				}
+			}
+		}
+		if req.WantReply {
+			req.Reply(false, nil)
+		}
+	}
+
+	switch {
+	case exitErr == nil:
+		s.exitC <- &ssh.ExitMissingError{}
+	case exitErr.Status == 0 && exitErr.Signal == "":
+		s.exitC <- nil
+	default:
+		s.exitC <- exitErr
+	}
+}
+
+// Wait waits until the server closes the session and returns nil if the
+// subsystem has exited successfully
+func (s *subsystemSession) Wait() error {
+	return <-s.exitC
+}
+
+// stdin returns the subsystem's stdin. closing it sends EOF and outputs of
+// the subsystem can still be read
+func (s *subsystemSession) stdin() io.WriteCloser {
+	return subsystemStdin{s.Channel}
+}
+
+type subsystemStdin struct {
+	ch ssh.Channel
+}
+
+func (w subsystemStdin) Write(b []byte) (int, error) {
+	return w.ch.Write(b)
+}
+
+func (w subsystemStdin) Close() error {
+	return w.ch.CloseWrite()
+}
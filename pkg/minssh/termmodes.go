@@ -0,0 +1,113 @@
+package minssh
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// terminalModeNames maps RFC 4254 mnemonics to their opcodes. it is used to
+// override modes by TerminalMode option
+var terminalModeNames = map[string]uint8{
+	"VINTR":         ssh.VINTR,
+	"VQUIT":         ssh.VQUIT,
+	"VERASE":        ssh.VERASE,
+	"VKILL":         ssh.VKILL,
+	"VEOF":          ssh.VEOF,
+	"VEOL":          ssh.VEOL,
+	"VEOL2":         ssh.VEOL2,
+	"VSTART":        ssh.VSTART,
+	"VSTOP":         ssh.VSTOP,
+	"VSUSP":         ssh.VSUSP,
+	"VDSUSP":        ssh.VDSUSP,
+	"VREPRINT":      ssh.VREPRINT,
+	"VWERASE":       ssh.VWERASE,
+	"VLNEXT":        ssh.VLNEXT,
+	"VFLUSH":        ssh.VFLUSH,
+	"VSWTCH":        ssh.VSWTCH,
+	"VSTATUS":       ssh.VSTATUS,
+	"VDISCARD":      ssh.VDISCARD,
+	"IGNPAR":        ssh.IGNPAR,
+	"PARMRK":        ssh.PARMRK,
+	"INPCK":         ssh.INPCK,
+	"ISTRIP":        ssh.ISTRIP,
+	"INLCR":         ssh.INLCR,
+	"IGNCR":         ssh.IGNCR,
+	"ICRNL":         ssh.ICRNL,
+	"IUCLC":         ssh.IUCLC,
+	"IXON":          ssh.IXON,
+	"IXANY":         ssh.IXANY,
+	"IXOFF":         ssh.IXOFF,
+	"IMAXBEL":       ssh.IMAXBEL,
+	"IUTF8":         ssh.IUTF8,
+	"ISIG":          ssh.ISIG,
+	"ICANON":        ssh.ICANON,
+	"XCASE":         ssh.XCASE,
+	"ECHO":          ssh.ECHO,
+	"ECHOE":         ssh.ECHOE,
+	"ECHOK":         ssh.ECHOK,
+	"ECHONL":        ssh.ECHONL,
+	"NOFLSH":        ssh.NOFLSH,
+	"TOSTOP":        ssh.TOSTOP,
+	"IEXTEN":        ssh.IEXTEN,
+	"ECHOCTL":       ssh.ECHOCTL,
+	"ECHOKE":        ssh.ECHOKE,
+	"PENDIN":        ssh.PENDIN,
+	"OPOST":         ssh.OPOST,
+	"OLCUC":         ssh.OLCUC,
+	"ONLCR":         ssh.ONLCR,
+	"OCRNL":         ssh.OCRNL,
+	"ONOCR":         ssh.ONOCR,
+	"ONLRET":        ssh.ONLRET,
+	"CS7":           ssh.CS7,
+	"CS8":           ssh.CS8,
+	"PARENB":        ssh.PARENB,
+	"PARODD":        ssh.PARODD,
+	"TTY_OP_ISPEED": ssh.TTY_OP_ISPEED,
+	"TTY_OP_OSPEED": ssh.TTY_OP_OSPEED,
+}
+
+// parseTerminalMode parses "NAME=VALUE" like "VERASE=^H", "ICRNL=0" or
+// "TTY_OP_ISPEED=38400". VALUE can be a number or a control character given
+// like "^X"
+func parseTerminalMode(s string) (opcode uint8, value uint32, err error) {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return 0, 0, fmt.Errorf("invalid terminal mode %q", s)
+	}
+	name, v := strings.ToUpper(s[:i]), s[i+1:]
+
+	opcode, ok := terminalModeNames[name]
+	if !ok {
+		return 0, 0, fmt.Errorf("unknown terminal mode %q", s[:i])
+	}
+
+	switch {
+	case v == "^?":
+		return opcode, uint32(keyDelete), nil
+	case len(v) == 2 && v[0] == '^':
+		return opcode, uint32(v[1] & 0x1f), nil
+	}
+	n, err := strconv.ParseUint(v, 0, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid value of terminal mode %q", s)
+	}
+	return opcode, uint32(n), nil
+}
+
+// terminalModes returns the local terminal settings sent with pty request.
+// modes given by TerminalMode option override them
+func (ms *MinSSH) terminalModes() ssh.TerminalModes {
+	modes, err := getTerminalModes(int(os.Stdin.Fd()))
+	if err != nil {
+		ms.conf.Logger.Printf("failed to get local terminal modes: %s\n", err)
+		modes = ssh.TerminalModes{}
+	}
+	for opcode, value := range ms.conf.TerminalModes {
+		modes[opcode] = value
+	}
+	return modes
+}
@@ -0,0 +1,21 @@
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package minssh
+
+import (
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/sys/unix"
+)
+
+const ioctlReadTermios = unix.TIOCGETA
+
+// addOSTerminalModes adds control characters only BSDs have
+func addOSTerminalModes(t *unix.Termios, modes ssh.TerminalModes) {
+	modes[ssh.VDSUSP] = uint32(t.Cc[unix.VDSUSP])
+	modes[ssh.VSTATUS] = uint32(t.Cc[unix.VSTATUS])
+}
+
+// termiosSpeeds returns input and output speeds. BSDs keep them as bit rates
+func termiosSpeeds(t *unix.Termios) (ispeed, ospeed uint32) {
+	return uint32(t.Ispeed), uint32(t.Ospeed)
+}
@@ -0,0 +1,68 @@
+package minssh
+
+import (
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/sys/unix"
+)
+
+const ioctlReadTermios = unix.TCGETS
+
+// addOSTerminalModes adds modes only Linux has
+func addOSTerminalModes(t *unix.Termios, modes ssh.TerminalModes) {
+	modes[ssh.VSWTCH] = uint32(t.Cc[unix.VSWTC])
+	setModeFlags(modes, uint64(t.Iflag), map[uint8]uint64{
+		ssh.IUCLC: unix.IUCLC,
+		ssh.IUTF8: unix.IUTF8,
+	})
+	setModeFlags(modes, uint64(t.Lflag), map[uint8]uint64{
+		ssh.XCASE: unix.XCASE,
+	})
+	setModeFlags(modes, uint64(t.Oflag), map[uint8]uint64{
+		ssh.OLCUC: unix.OLCUC,
+	})
+}
+
+// baudRates maps speed codes in c_cflag to bit rates
+var baudRates = map[uint32]uint32{
+	unix.B0:       0,
+	unix.B50:      50,
+	unix.B75:      75,
+	unix.B110:     110,
+	unix.B134:     134,
+	unix.B150:     150,
+	unix.B200:     200,
+	unix.B300:     300,
+	unix.B600:     600,
+	unix.B1200:    1200,
+	unix.B1800:    1800,
+	unix.B2400:    2400,
+	unix.B4800:    4800,
+	unix.B9600:    9600,
+	unix.B19200:   19200,
+	unix.B38400:   38400,
+	unix.B57600:   57600,
+	unix.B115200:  115200,
+	unix.B230400:  230400,
+	unix.B460800:  460800,
+	unix.B500000:  500000,
+	unix.B576000:  576000,
+	unix.B921600:  921600,
+	unix.B1000000: 1000000,
+	unix.B1152000: 1152000,
+	unix.B1500000: 1500000,
+	unix.B2000000: 2000000,
+	unix.B2500000: 2500000,
+	unix.B3000000: 3000000,
+	unix.B3500000: 3500000,
+	unix.B4000000: 4000000,
+}
+
+// termiosSpeeds returns input and output speeds. TCGETS doesn't fill c_ispeed
+// and c_ospeed so that they are decoded from c_cflag like cfgetispeed(3)
+func termiosSpeeds(t *unix.Termios) (ispeed, ospeed uint32) {
+	speed, ok := baudRates[uint32(t.Cflag)&unix.CBAUD]
+	if !ok {
+		speed = 38400
+	}
+	return speed, speed
+}
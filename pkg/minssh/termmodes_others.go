@@ -0,0 +1,13 @@
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package minssh
+
+import (
+	"golang.org/x/crypto/ssh"
+)
+
+// getTerminalModes returns no modes on platforms without termios. only modes
+// given by TerminalMode option are sent
+func getTerminalModes(fd int) (ssh.TerminalModes, error) {
+	return ssh.TerminalModes{}, nil
+}
@@ -0,0 +1,106 @@
+package minssh
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseTerminalMode(t *testing.T) {
+	tests := []struct {
+		s       string
+		opcode  uint8
+		value   uint32
+		wantErr bool
+	}{
+		{s: "VERASE=^?", opcode: ssh.VERASE, value: 0x7f},
+		{s: "VERASE=^H", opcode: ssh.VERASE, value: 0x08},
+		{s: "verase=^h", opcode: ssh.VERASE, value: 0x08},
+		{s: "VINTR=^C", opcode: ssh.VINTR, value: 0x03},
+		{s: "VKILL=21", opcode: ssh.VKILL, value: 21},
+		{s: "VKILL=0x15", opcode: ssh.VKILL, value: 21},
+		{s: "ICRNL=0", opcode: ssh.ICRNL, value: 0},
+		{s: "IUTF8=1", opcode: ssh.IUTF8, value: 1},
+		{s: "TTY_OP_ISPEED=38400", opcode: ssh.TTY_OP_ISPEED, value: 38400},
+		{s: "VERASE", wantErr: true},
+		{s: "=1", wantErr: true},
+		{s: "NOSUCHMODE=1", wantErr: true},
+		{s: "VERASE=", wantErr: true},
+		{s: "VERASE=^", wantErr: true},
+		{s: "VERASE=^^?", wantErr: true},
+		{s: "VERASE=DEL", wantErr: true},
+		{s: "VERASE=-1", wantErr: true},
+		{s: "TTY_OP_ISPEED=4294967296", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		opcode, value, err := parseTerminalMode(tt.s)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseTerminalMode(%q) returned no error", tt.s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTerminalMode(%q) returned error: %s", tt.s, err)
+			continue
+		}
+		if opcode != tt.opcode || value != tt.value {
+			t.Errorf("parseTerminalMode(%q) = %d, %d, want %d, %d", tt.s, opcode, value, tt.opcode, tt.value)
+		}
+	}
+}
+
+func TestTerminalModeOption(t *testing.T) {
+	tests := []struct {
+		values  [][]string
+		want    ssh.TerminalModes
+		wantErr bool
+	}{
+		{
+			values: [][]string{{"VERASE=^?", "ICRNL=0"}},
+			want:   ssh.TerminalModes{ssh.VERASE: 0x7f, ssh.ICRNL: 0},
+		},
+		{
+			// the first value is used for each mode
+			values: [][]string{{"VERASE=^H"}, {"VERASE=^?", "VINTR=^C"}},
+			want:   ssh.TerminalModes{ssh.VERASE: 0x08, ssh.VINTR: 0x03},
+		},
+		{
+			values: [][]string{{"VERASE=^H", "VERASE=^?"}},
+			want:   ssh.TerminalModes{ssh.VERASE: 0x08},
+		},
+		{
+			values:  [][]string{{"VERASE=^H", "NOSUCHMODE=1"}},
+			wantErr: true,
+		},
+		{
+			values:  [][]string{{"VERASE=DEL"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		conf := NewConfig()
+		var err error
+		for _, args := range tt.values {
+			if err = conf.SetOption("TerminalMode", args...); err != nil {
+				break
+			}
+		}
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("TerminalMode %q returned no error", tt.values)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("TerminalMode %q returned error: %s", tt.values, err)
+			continue
+		}
+		if !reflect.DeepEqual(conf.TerminalModes, tt.want) {
+			t.Errorf("TerminalMode %q = %v, want %v", tt.values, conf.TerminalModes, tt.want)
+		}
+	}
+}
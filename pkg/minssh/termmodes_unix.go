@@ -0,0 +1,103 @@
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package minssh
+
+import (
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/sys/unix"
+)
+
+// getTerminalModes reads termios of fd and converts it to the terminal modes
+// encoding of RFC 4254 like OpenSSH does
+func getTerminalModes(fd int) (ssh.TerminalModes, error) {
+	t, err := unix.IoctlGetTermios(fd, ioctlReadTermios)
+	if err != nil {
+		return nil, err
+	}
+
+	modes := ssh.TerminalModes{}
+
+	cc := map[uint8]int{
+		ssh.VINTR:    unix.VINTR,
+		ssh.VQUIT:    unix.VQUIT,
+		ssh.VERASE:   unix.VERASE,
+		ssh.VKILL:    unix.VKILL,
+		ssh.VEOF:     unix.VEOF,
+		ssh.VEOL:     unix.VEOL,
+		ssh.VEOL2:    unix.VEOL2,
+		ssh.VSTART:   unix.VSTART,
+		ssh.VSTOP:    unix.VSTOP,
+		ssh.VSUSP:    unix.VSUSP,
+		ssh.VREPRINT: unix.VREPRINT,
+		ssh.VWERASE:  unix.VWERASE,
+		ssh.VLNEXT:   unix.VLNEXT,
+		ssh.VDISCARD: unix.VDISCARD,
+	}
+	for opcode, i := range cc {
+		modes[opcode] = uint32(t.Cc[i])
+	}
+
+	setModeFlags(modes, uint64(t.Iflag), map[uint8]uint64{
+		ssh.IGNPAR:  unix.IGNPAR,
+		ssh.PARMRK:  unix.PARMRK,
+		ssh.INPCK:   unix.INPCK,
+		ssh.ISTRIP:  unix.ISTRIP,
+		ssh.INLCR:   unix.INLCR,
+		ssh.IGNCR:   unix.IGNCR,
+		ssh.ICRNL:   unix.ICRNL,
+		ssh.IXON:    unix.IXON,
+		ssh.IXANY:   unix.IXANY,
+		ssh.IXOFF:   unix.IXOFF,
+		ssh.IMAXBEL: unix.IMAXBEL,
+	})
+	setModeFlags(modes, uint64(t.Lflag), map[uint8]uint64{
+		ssh.ISIG:    unix.ISIG,
+		ssh.ICANON:  unix.ICANON,
+		ssh.ECHO:    unix.ECHO,
+		ssh.ECHOE:   unix.ECHOE,
+		ssh.ECHOK:   unix.ECHOK,
+		ssh.ECHONL:  unix.ECHONL,
+		ssh.NOFLSH:  unix.NOFLSH,
+		ssh.TOSTOP:  unix.TOSTOP,
+		ssh.IEXTEN:  unix.IEXTEN,
+		ssh.ECHOCTL: unix.ECHOCTL,
+		ssh.ECHOKE:  unix.ECHOKE,
+		ssh.PENDIN:  unix.PENDIN,
+	})
+	setModeFlags(modes, uint64(t.Oflag), map[uint8]uint64{
+		ssh.OPOST:  unix.OPOST,
+		ssh.ONLCR:  unix.ONLCR,
+		ssh.OCRNL:  unix.OCRNL,
+		ssh.ONOCR:  unix.ONOCR,
+		ssh.ONLRET: unix.ONLRET,
+	})
+	setModeFlags(modes, uint64(t.Cflag), map[uint8]uint64{
+		ssh.PARENB: unix.PARENB,
+		ssh.PARODD: unix.PARODD,
+	})
+	addOSTerminalModes(t, modes)
+
+	// CS7 and CS8 are values of the character size field, not bits
+	modes[ssh.CS7], modes[ssh.CS8] = 0, 0
+	switch uint64(t.Cflag) & unix.CSIZE {
+	case unix.CS7:
+		modes[ssh.CS7] = 1
+	case unix.CS8:
+		modes[ssh.CS8] = 1
+	}
+
+	modes[ssh.TTY_OP_ISPEED], modes[ssh.TTY_OP_OSPEED] = termiosSpeeds(t)
+
+	return modes, nil
+}
+
+// setModeFlags sets 1 to modes whose bits are set in v and 0 to others
+func setModeFlags(modes ssh.TerminalModes, v uint64, bits map[uint8]uint64) {
+	for opcode, bit := range bits {
+		if v&bit != 0 {
+			modes[opcode] = 1
+		} else {
+			modes[opcode] = 0
+		}
+	}
+}
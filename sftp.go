@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/tatsushid/minssh/pkg/minssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const progressInterval = 200 * time.Millisecond
+
+func openSFTP(ms *minssh.MinSSH) (client *sftp.Client, sub *minssh.Subsystem, err error) {
+	sub, err = ms.OpenSubsystem("sftp")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client, err = sftp.NewClientPipe(sub.Stdout, sub.Stdin)
+	if err != nil {
+		sub.Close()
+		return nil, nil, fmt.Errorf("failed to start sftp session: %s", err)
+	}
+
+	return client, sub, nil
+}
+
+type sftpShell struct {
+	client *sftp.Client
+	cwd    string
+	out    io.Writer
+	// progressOut is nil in quiet mode
+	progressOut io.Writer
+}
+
+type sftpCommand struct {
+	usage string
+	help  string
+	run   func(sh *sftpShell, args []string) error
+}
+
+var sftpCommands map[string]*sftpCommand
+
+func init() {
+	// it is initialized here to refer sftpCommands itself in "help"
+	sftpCommands = map[string]*sftpCommand{
+		"cd":     {"cd path", "change remote directory to 'path'", (*sftpShell).cd},
+		"get":    {"get remote [local]", "download a remote file", (*sftpShell).get},
+		"help":   {"help", "show this help", (*sftpShell).help},
+		"lcd":    {"lcd path", "change local directory to 'path'", (*sftpShell).lcd},
+		"lpwd":   {"lpwd", "print local working directory", (*sftpShell).lpwd},
+		"ls":     {"ls [-l] [path]", "list remote directory", (*sftpShell).ls},
+		"mkdir":  {"mkdir path", "create remote directory", (*sftpShell).mkdir},
+		"put":    {"put local [remote]", "upload a local file", (*sftpShell).put},
+		"pwd":    {"pwd", "print remote working directory", (*sftpShell).pwd},
+		"rename": {"rename oldpath newpath", "rename remote file", (*sftpShell).rename},
+		"rm":     {"rm path", "remove remote file", (*sftpShell).rm},
+		"rmdir":  {"rmdir path", "remove remote directory", (*sftpShell).rmdir},
+	}
+	sftpCommands["?"] = sftpCommands["help"]
+}
+
+func (a *app) runSFTP(ms *minssh.MinSSH) error {
+	client, sub, err := openSFTP(ms)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+	defer client.Close()
+
+	sh := &sftpShell{client: client, out: os.Stdout}
+	if !a.conf.Quiet {
+		sh.progressOut = os.Stdout
+	}
+	if sh.cwd, err = client.Getwd(); err != nil {
+		return fmt.Errorf("failed to get remote working directory: %s", err)
+	}
+
+	interactive := terminal.IsTerminal(int(os.Stdin.Fd()))
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		if interactive {
+			fmt.Fprint(sh.out, "sftp> ")
+		}
+		if !scanner.Scan() {
+			break
+		}
+
+		args, err := minssh.SplitArgs(scanner.Text())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "exit" || args[0] == "quit" || args[0] == "bye" {
+			return nil
+		}
+
+		cmd, ok := sftpCommands[args[0]]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "invalid command %q. type 'help' to show commands\n", args[0])
+			continue
+		}
+		if err = cmd.run(sh, args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", args[0], err)
+		}
+	}
+	if interactive {
+		fmt.Fprintln(sh.out)
+	}
+
+	return scanner.Err()
+}
+
+func (sh *sftpShell) remotePath(p string) string {
+	if path.IsAbs(p) {
+		return path.Clean(p)
+	}
+	return path.Join(sh.cwd, p)
+}
+
+func (sh *sftpShell) help(args []string) error {
+	fmt.Fprintln(sh.out, "Available commands:")
+	var names []string
+	for name := range sftpCommands {
+		if name != "?" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmd := sftpCommands[name]
+		fmt.Fprintf(sh.out, "  %-24s %s\n", cmd.usage, cmd.help)
+	}
+	fmt.Fprintf(sh.out, "  %-24s %s\n", "exit", "quit sftp")
+	return nil
+}
+
+func (sh *sftpShell) cd(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s", sftpCommands["cd"].usage)
+	}
+	p := sh.remotePath(args[0])
+	fi, err := sh.client.Stat(p)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", p)
+	}
+	sh.cwd = p
+	return nil
+}
+
+func (sh *sftpShell) pwd(args []string) error {
+	fmt.Fprintf(sh.out, "Remote working directory: %s\n", sh.cwd)
+	return nil
+}
+
+func (sh *sftpShell) lcd(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s", sftpCommands["lcd"].usage)
+	}
+	return os.Chdir(args[0])
+}
+
+func (sh *sftpShell) lpwd(args []string) error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(sh.out, "Local working directory: %s\n", dir)
+	return nil
+}
+
+func (sh *sftpShell) ls(args []string) error {
+	long := false
+	if len(args) > 0 && args[0] == "-l" {
+		long = true
+		args = args[1:]
+	}
+	p := sh.cwd
+	if len(args) > 0 {
+		p = sh.remotePath(args[0])
+	}
+
+	fi, err := sh.client.Stat(p)
+	if err != nil {
+		return err
+	}
+	entries := []os.FileInfo{fi}
+	if fi.IsDir() {
+		if entries, err = sh.client.ReadDir(p); err != nil {
+			return err
+		}
+	}
+
+	for _, e := range entries {
+		if long {
+			fmt.Fprintf(sh.out, "%s %10d %s %s\n", e.Mode(), e.Size(), e.ModTime().Format("Jan _2 15:04 2006"), e.Name())
+		} else {
+			fmt.Fprintln(sh.out, e.Name())
+		}
+	}
+	return nil
+}
+
+func (sh *sftpShell) mkdir(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s", sftpCommands["mkdir"].usage)
+	}
+	return sh.client.Mkdir(sh.remotePath(args[0]))
+}
+
+func (sh *sftpShell) rm(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s", sftpCommands["rm"].usage)
+	}
+	return sh.client.Remove(sh.remotePath(args[0]))
+}
+
+func (sh *sftpShell) rmdir(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s", sftpCommands["rmdir"].usage)
+	}
+	return sh.client.RemoveDirectory(sh.remotePath(args[0]))
+}
+
+func (sh *sftpShell) rename(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: %s", sftpCommands["rename"].usage)
+	}
+	return sh.client.Rename(sh.remotePath(args[0]), sh.remotePath(args[1]))
+}
+
+func (sh *sftpShell) get(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: %s", sftpCommands["get"].usage)
+	}
+	src := sh.remotePath(args[0])
+	dst := path.Base(src)
+	if len(args) == 2 {
+		dst = args[1]
+	}
+	if fi, err := os.Stat(dst); err == nil && fi.IsDir() {
+		dst = filepath.Join(dst, path.Base(src))
+	}
+
+	if sh.progressOut != nil {
+		fmt.Fprintf(sh.progressOut, "Fetching %s to %s\n", src, dst)
+	}
+	return download(sh.client, src, dst, sh.progressOut)
+}
+
+func (sh *sftpShell) put(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: %s", sftpCommands["put"].usage)
+	}
+	src := args[0]
+	dst := sh.remotePath(filepath.Base(src))
+	if len(args) == 2 {
+		dst = sh.remotePath(args[1])
+	}
+	if fi, err := sh.client.Stat(dst); err == nil && fi.IsDir() {
+		dst = path.Join(dst, filepath.Base(src))
+	}
+
+	if sh.progressOut != nil {
+		fmt.Fprintf(sh.progressOut, "Uploading %s to %s\n", src, dst)
+	}
+	return upload(sh.client, src, dst, sh.progressOut)
+}
+
+func download(client *sftp.Client, src, dst string, progressOut io.Writer) error {
+	r, err := client.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	fi, err := r.Stat()
+	if err != nil {
+		return err
+	}
+
+	w, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	err = copyWithProgress(w, r, path.Base(src), fi.Size(), progressOut)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func upload(client *sftp.Client, src, dst string, progressOut io.Writer) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	fi, err := r.Stat()
+	if err != nil {
+		return err
+	}
+
+	w, err := client.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	err = copyWithProgress(w, r, filepath.Base(src), fi.Size(), progressOut)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// progressWriter prints how many bytes have been written to out
+type progressWriter struct {
+	out   io.Writer
+	name  string
+	total int64
+	n     int64
+	start time.Time
+	last  time.Time
+}
+
+func copyWithProgress(w io.Writer, r io.Reader, name string, total int64, out io.Writer) error {
+	if out == nil {
+		_, err := io.Copy(w, r)
+		return err
+	}
+
+	p := &progressWriter{out: out, name: name, total: total, start: time.Now()}
+	_, err := io.Copy(w, io.TeeReader(r, p))
+	p.print()
+	fmt.Fprintln(out)
+	return err
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.n += int64(len(b))
+	if time.Since(p.last) >= progressInterval {
+		p.print()
+		p.last = time.Now()
+	}
+	return len(b), nil
+}
+
+func (p *progressWriter) print() {
+	percent := int64(100)
+	if p.total > 0 {
+		percent = p.n * 100 / p.total
+	}
+	rate := float64(p.n) / time.Since(p.start).Seconds()
+	fmt.Fprintf(p.out, "\r%-30s %3d%% %10s %10s/s", p.name, percent, formatSize(float64(p.n)), formatSize(rate))
+}
+
+func formatSize(n float64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f%s", n, units[i])
+	}
+	return fmt.Sprintf("%.1f%s", n, units[i])
+}